@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// catalog is the parsed description of the box: the device tree with all services, their actions and state
+// variables. It's fetched once and only refreshed after the refresh interval or if the box was rebooted or updated.
+type catalog struct {
+	Root            Device    `json:"root"`
+	SoftwareVersion string    `json:"softwareVersion"`
+	UpTime          float64   `json:"upTime"`
+	Fetched         time.Time `json:"fetched"`
+
+	// services of all devices, not persisted
+	services []Service
+	// incomplete is set if descriptions of services couldn't be fetched, the catalog is fetched again on the next
+	// validation then and isn't persisted
+	incomplete bool
+}
+
+func newCatalog(root Device, fetched time.Time) *catalog {
+	return &catalog{
+		Root:     root,
+		Fetched:  fetched,
+		services: root.allServices(),
+	}
+}
+
+// allServices returns the services of the device and all sub-devices in document order
+func (d Device) allServices() []Service {
+	services := append([]Service(nil), d.Services...)
+	for _, device := range d.Devices {
+		services = append(services, device.allServices()...)
+	}
+	return services
+}
+
+// allDevices returns the device and all sub-devices in depth-first order
+func (d Device) allDevices() []Device {
+	devices := []Device{d}
+	for _, device := range d.Devices {
+		devices = append(devices, device.allDevices()...)
+	}
+	return devices
+}
+
+// Devices returns all devices of the box (e.g. InternetGatewayDevice, LANDevice, WANDevice, WANConnectionDevice) in
+// depth-first order, each with its services and sub-devices
+func (uc *UPnPClient) Devices(ctx context.Context) ([]Device, error) {
+	catalog, err := uc.loadCatalog(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Root.allDevices(), nil
+}
+
+// loadCatalog returns the catalog, which is read from the catalog file or fetched from the box on first use. With
+// validate set (or on first use), the catalog is fetched again if the refresh interval is over, if uptime or software
+// version of the box show a reboot or update or if it is incomplete. The cached catalog is kept if this check fails.
+func (uc *UPnPClient) loadCatalog(ctx context.Context, validate bool) (*catalog, error) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	if uc.catalog == nil && len(uc.catalogFile) > 0 {
+		uc.catalog = readCatalog(uc.catalogFile)
+		validate = true
+	}
+	if uc.catalog != nil && validate {
+		if uc.catalog.incomplete {
+			catalog, err := uc.fetchCatalog(ctx)
+			if err != nil {
+				log.Warnf("can't fetch incomplete service descriptions again, keeping them: %v", err)
+				return uc.catalog, nil
+			}
+			uc.catalog = catalog
+		} else if err := uc.validateCatalog(ctx); err != nil {
+			log.Warnf("can't check whether the service descriptions are outdated, keeping them: %v", err)
+		}
+	}
+	if uc.catalog != nil {
+		return uc.catalog, nil
+	}
+
+	catalog, err := uc.fetchCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	uc.catalog = catalog
+	return catalog, nil
+}
+
+// fetchCatalog fetches the descriptions of all services from the box, only complete catalogs are persisted
+func (uc *UPnPClient) fetchCatalog(ctx context.Context) (*catalog, error) {
+	root, incomplete, err := uc.parseServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	catalog := newCatalog(root, time.Now())
+	catalog.incomplete = incomplete
+	if catalog.SoftwareVersion, catalog.UpTime, err = uc.deviceInfo(ctx, catalog); err != nil {
+		log.Warnf("can't get software version of the box: %v", err)
+	}
+	log.Infof("fetched descriptions of %d services (software version %s)", len(catalog.services), catalog.SoftwareVersion)
+
+	if incomplete {
+		log.Warnf("descriptions of some services are missing, fetching them again on the next poll")
+	} else if len(uc.catalogFile) > 0 {
+		if err := writeCatalog(uc.catalogFile, catalog); err != nil {
+			log.Warnf("can't write catalog file: %v", err)
+		}
+	}
+	return catalog, nil
+}
+
+// validateCatalog drops the catalog if it's outdated
+func (uc *UPnPClient) validateCatalog(ctx context.Context) error {
+	catalog := uc.catalog
+	if uc.catalogRefresh > 0 && time.Since(catalog.Fetched) > uc.catalogRefresh {
+		log.Debugf("service descriptions are older than %v, refreshing", uc.catalogRefresh)
+		uc.catalog = nil
+		return nil
+	}
+
+	softwareVersion, upTime, err := uc.deviceInfo(ctx, catalog)
+	if err != nil {
+		return err
+	}
+	if softwareVersion != catalog.SoftwareVersion || upTime < catalog.UpTime {
+		log.Infof("box was rebooted or updated (software version %s), refreshing service descriptions", softwareVersion)
+		uc.catalog = nil
+		return nil
+	}
+	catalog.UpTime = upTime
+	return nil
+}
+
+// deviceInfo returns software version and uptime of the box (DeviceInfo/GetInfo), both are empty if the box doesn't
+// provide them
+func (uc *UPnPClient) deviceInfo(ctx context.Context, catalog *catalog) (string, float64, error) {
+	for _, service := range catalog.services {
+		if !strings.Contains(service.ServiceType, "DeviceInfo") {
+			continue
+		}
+		action, err := findAction(service, "GetInfo", nil)
+		if err != nil {
+			return "", 0, nil
+		}
+		content, err := uc.invoke(ctx, service, action, nil)
+		if err != nil {
+			return "", 0, &ActionError{ServiceType: service.ServiceType, Action: action.Name, Err: err}
+		}
+		defer content.Close()
+
+		values := decodeResponse(content)
+		return values["NewSoftwareVersion"], extract(values["NewUpTime"]), nil
+	}
+	return "", 0, nil
+}
+
+// readCatalog reads the catalog file, returns nil if there is none or it can't be parsed
+func readCatalog(file string) *catalog {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("can't read catalog file: %v", err)
+		}
+		return nil
+	}
+
+	var result catalog
+	if err := json.Unmarshal(content, &result); err != nil {
+		log.Warnf("can't parse catalog file %s: %v", file, err)
+		return nil
+	}
+	result.services = result.Root.allServices()
+	return &result
+}
+
+// writeCatalog stores the catalog, the file is replaced atomically
+func writeCatalog(file string, catalog *catalog) error {
+	content, err := json.Marshal(catalog)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_catalogIsCachedUntilReboot(t *testing.T) {
+	upTime := "3600"
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		return map[string]string{"NewSoftwareVersion": "154.07.29", "NewUpTime": upTime, "NewHostNumberOfEntries": "3"}, 0
+	})
+	defer box.Close()
+	sut := box.client()
+
+	for i := 0; i < 3; i++ {
+		_, err := sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, box.requestCount(ENDPOINT))
+	assert.Equal(t, 1, box.requestCount("/hostsSCPD.xml"))
+	assert.Equal(t, 3, box.requestCount("GetHostNumberOfEntries"))
+
+	// reboot
+	upTime = "60"
+	_, err := sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, box.requestCount(ENDPOINT))
+}
+
+func Test_catalogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		return map[string]string{"NewSoftwareVersion": "154.07.29", "NewUpTime": "3600"}, 0
+	})
+	defer box.Close()
+
+	for i := 0; i < 2; i++ {
+		// restart
+		sut, _ := NewUPnPClient(&Config{CatalogFile: filepath.Join(dir, "catalog.json")})
+		sut.URL = box.URL
+
+		devices, err := sut.Devices(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, devices, 4)
+		assert.Len(t, devices[1].Services[0].Actions, 3)
+	}
+	assert.Equal(t, 1, box.requestCount(ENDPOINT))
+}
+
+func Test_catalogIsKeptIfValidationFails(t *testing.T) {
+	denied := false
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		if action == "GetInfo" && denied {
+			return nil, upnpErrorNotAuthorized
+		}
+		return map[string]string{"NewSoftwareVersion": "154.07.29", "NewUpTime": "3600", "NewHostNumberOfEntries": "3"}, 0
+	})
+	defer box.Close()
+	sut := box.client()
+
+	_, err := sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+	assert.NoError(t, err)
+
+	denied = true
+	values, err := sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+
+	assert.NoError(t, err)
+	assert.Len(t, values, 1)
+	assert.Equal(t, 1, box.requestCount(ENDPOINT))
+}
+
+func Test_incompleteCatalogIsFetchedAgain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "catalog.json")
+
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		return map[string]string{"NewSoftwareVersion": "154.07.29", "NewUpTime": "3600", "NewHostNumberOfEntries": "3"}, 0
+	})
+	box.failures["/hostsSCPD.xml"] = 1
+	defer box.Close()
+	sut, _ := NewUPnPClient(&Config{CatalogFile: file})
+	sut.URL = box.URL
+
+	// description of Hosts is missing, the catalog isn't persisted
+	values, err := sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+
+	// next poll fetches the descriptions again
+	values, err = sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+	assert.NoError(t, err)
+	assert.Len(t, values, 1)
+	assert.Equal(t, 2, box.requestCount(ENDPOINT))
+	assert.Equal(t, 2, box.requestCount("/hostsSCPD.xml"))
+	_, err = os.Stat(file)
+	assert.NoError(t, err)
+
+	// complete catalog is cached
+	_, err = sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, box.requestCount(ENDPOINT))
+}
@@ -3,15 +3,48 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Config struct {
 	URL      string
 	User     string
 	Password string
+	// WebUI enables collectors which use the web UI data pages
+	WebUI bool
+	// TLS enables the TR-064 HTTPS port, the box certificate is verified by the system CAs, the CA file or the pinned
+	// fingerprint
+	TLS            bool
+	CAFile         string
+	TLSFingerprint string
+	TLSPinFile     string
+	// timeouts of a single request to the box, Retries is the number of retries of idempotent requests
+	ConnectTimeout time.Duration
+	RequestTimeout time.Duration
+	Retries        int
+	// Concurrency is the maximum number of concurrent requests to the box
+	Concurrency int
+	// CatalogRefresh is the maximum age of the cached service descriptions, CatalogFile persists them (optional)
+	CatalogRefresh time.Duration
+	CatalogFile    string
+	// PollTimeout is the maximum duration of a poll on scrape, which is shared by concurrent scrapes and cancelled when
+	// all of them gave up (0 = unlimited)
+	PollTimeout time.Duration
+	// PollInterval enables polling the box in background, scrapes are served from the last poll (0 = poll on scrape)
+	PollInterval time.Duration
+	// MaxStaleness is the maximum age of the last successful poll served in background mode, it must be at least twice
+	// the poll interval, so a poll can take up to an interval (0 = unlimited)
+	MaxStaleness time.Duration
 }
 
+// defaultMaxStaleness is the maximum staleness unless configured, longer poll intervals raise it to twice the interval
+const defaultMaxStaleness = 5 * time.Minute
+
 func parse(config *Config) error {
 	url := "192.168.178.1"
 	if len(os.Getenv("FB_URL")) > 0 {
@@ -20,11 +53,76 @@ func parse(config *Config) error {
 	flag.StringVar(&config.URL, "url", url, "FritzBox URL")
 	flag.StringVar(&config.User, "user", os.Getenv("FB_USERNAME"), "user name")
 	flag.StringVar(&config.Password, "password", os.Getenv("FB_PASSWORD"), "password")
+	flag.BoolVar(&config.WebUI, "webui", os.Getenv("FB_WEBUI") == "true", "enable collectors using the web UI (DOCSIS, fiber, CPU/RAM, WLAN environment)")
+	flag.BoolVar(&config.TLS, "tls", os.Getenv("FB_TLS") == "true", "use HTTPS for TR-064")
+	flag.StringVar(&config.CAFile, "ca-file", os.Getenv("FB_CA_FILE"), "CA file to verify the box certificate")
+	flag.StringVar(&config.TLSFingerprint, "tls-fingerprint", os.Getenv("FB_TLS_FINGERPRINT"), "pinned SHA256 fingerprint of the box certificate")
+	flag.StringVar(&config.TLSPinFile, "tls-pin-file", os.Getenv("FB_TLS_PIN_FILE"), "file to store the box certificate fingerprint on first use")
+	flag.DurationVar(&config.ConnectTimeout, "connect-timeout", envDuration("FB_CONNECT_TIMEOUT", 5*time.Second), "timeout of connecting to the box")
+	flag.DurationVar(&config.RequestTimeout, "request-timeout", envDuration("FB_REQUEST_TIMEOUT", 10*time.Second), "timeout of a single request to the box")
+	flag.IntVar(&config.Retries, "retries", envInt("FB_RETRIES", 2), "number of retries of failed read requests")
+	flag.IntVar(&config.Concurrency, "concurrency", envInt("FB_CONCURRENCY", 4), "maximum number of concurrent requests to the box")
+	flag.DurationVar(&config.CatalogRefresh, "catalog-refresh", envDuration("FB_CATALOG_REFRESH", 24*time.Hour), "maximum age of the cached service descriptions (0 = until reboot or update of the box)")
+	flag.StringVar(&config.CatalogFile, "catalog-file", os.Getenv("FB_CATALOG_FILE"), "file to persist the service descriptions across restarts")
+	flag.DurationVar(&config.PollTimeout, "poll-timeout", envDuration("FB_POLL_TIMEOUT", time.Minute), "maximum duration of a poll on scrape, the poll is cancelled when all waiting scrapes reached their timeout")
+	flag.DurationVar(&config.PollInterval, "poll-interval", envDuration("FB_POLL_INTERVAL", 0), "interval of polling the box in background, scrapes are served from the last poll (0 = poll on each scrape)")
+	flag.DurationVar(&config.MaxStaleness, "max-staleness", envDuration("FB_MAX_STALENESS", defaultMaxStaleness), "maximum age of the values served in background polling mode, at least twice the poll interval, which is the default for poll intervals above 2m30s (0 = unlimited)")
 	flag.Parse()
 
 	if len(config.User) == 0 || len(config.Password) == 0 {
 		return errors.New("please enter user name / password")
 	}
+	if _, ok := os.LookupEnv("FB_MAX_STALENESS"); !ok && !isFlagSet("max-staleness") {
+		config.MaxStaleness = maxStalenessFor(config.PollInterval)
+	}
+	return validatePolling(config)
+}
+
+// maxStalenessFor returns the default maximum staleness for passed poll interval
+func maxStalenessFor(pollInterval time.Duration) time.Duration {
+	if 2*pollInterval > defaultMaxStaleness {
+		return 2 * pollInterval
+	}
+	return defaultMaxStaleness
+}
 
+// validatePolling checks that the maximum staleness leaves room for a poll of up to one poll interval
+func validatePolling(config *Config) error {
+	if config.PollInterval > 0 && config.MaxStaleness > 0 && config.MaxStaleness < 2*config.PollInterval {
+		return fmt.Errorf("max staleness %v is shorter than twice the poll interval %v", config.MaxStaleness, config.PollInterval)
+	}
 	return nil
 }
+
+// isFlagSet returns true if the flag with passed name is given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(name); ok {
+		duration, err := time.ParseDuration(value)
+		if err == nil {
+			return duration
+		}
+		log.Warnf("invalid duration '%s' in %s, using %v", value, name, defaultValue)
+	}
+	return defaultValue
+}
+
+func envInt(name string, defaultValue int) int {
+	if value, ok := os.LookupEnv(name); ok {
+		number, err := strconv.Atoi(value)
+		if err == nil {
+			return number
+		}
+		log.Warnf("invalid number '%s' in %s, using %d", value, name, defaultValue)
+	}
+	return defaultValue
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_maxStalenessFor(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, maxStalenessFor(0))
+	assert.Equal(t, 5*time.Minute, maxStalenessFor(time.Minute))
+	assert.Equal(t, 20*time.Minute, maxStalenessFor(10*time.Minute))
+}
+
+func Test_validatePolling(t *testing.T) {
+	tests := []struct {
+		name         string
+		pollInterval time.Duration
+		maxStaleness time.Duration
+		valid        bool
+	}{
+		{"poll on scrape", 0, time.Minute, true},
+		{"unlimited", 10 * time.Minute, 0, true},
+		{"default", 10 * time.Minute, maxStalenessFor(10 * time.Minute), true},
+		{"twice the interval", time.Minute, 2 * time.Minute, true},
+		{"equal to the interval", time.Minute, time.Minute, false},
+		{"no time for the poll", time.Minute, 90 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePolling(&Config{PollInterval: tt.pollInterval, MaxStaleness: tt.maxStaleness})
+			assert.Equal(t, tt.valid, err == nil)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// deviceTypeName returns the device type without URN prefix, e.g. "WANConnectionDevice:1"
+func deviceTypeName(deviceType string) string {
+	return strings.TrimPrefix(deviceType, "urn:dslforum-org:device:")
+}
+
+func (collector *FritzBoxCollector) collectDevices(ctx context.Context, ch chan<- prometheus.Metric, uPnPClient *UPnPClient) {
+	devices, err := uPnPClient.Devices(ctx)
+	if err != nil {
+		log.Warnf("can't get devices: %v", err)
+		return
+	}
+
+	deviceInfo := prometheus.NewDesc(
+		"fb_device_info",
+		"Devices of the box, their services provide the metrics with the same udn",
+		[]string{"udn", "device_type", "friendly_name", "model_name"},
+		nil,
+	)
+	for _, device := range devices {
+		ch <- prometheus.MustNewConstMetric(deviceInfo, prometheus.GaugeValue, 1,
+			device.UDN, deviceTypeName(device.DeviceType), device.FriendlyName, device.ModelName)
+	}
+}
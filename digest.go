@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestChallenge is a parsed "WWW-Authenticate: Digest ..." header (RFC 7616)
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	stale     bool
+}
+
+// parseDigestChallenge parses the first digest challenge of passed WWW-Authenticate header values, challenges with
+// SHA-256 are preferred
+func parseDigestChallenge(headers []string) (*digestChallenge, error) {
+	var result *digestChallenge
+	for _, header := range headers {
+		scheme := strings.SplitN(strings.TrimSpace(header), " ", 2)
+		if len(scheme) != 2 || !strings.EqualFold(scheme[0], "Digest") {
+			continue
+		}
+		params := parseAuthParams(scheme[1])
+		challenge := &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: strings.ToUpper(params["algorithm"]),
+			stale:     strings.EqualFold(params["stale"], "true"),
+		}
+		if challenge.algorithm == "" {
+			challenge.algorithm = "MD5"
+		}
+		if _, err := challenge.hash(); err != nil || challenge.nonce == "" {
+			continue
+		}
+		for _, qop := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				challenge.qop = "auth"
+			}
+		}
+		if result == nil || strings.HasPrefix(challenge.algorithm, "SHA-256") {
+			result = challenge
+		}
+	}
+	if result == nil {
+		return nil, errors.New("no supported digest challenge")
+	}
+	return result, nil
+}
+
+// parseAuthParams parses comma separated auth params (name=token or name="quoted string")
+func parseAuthParams(s string) map[string]string {
+	result := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " \t,")
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i < len(s) {
+				// closing quote
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.Index(s, ",")
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:end]))
+			s = s[end:]
+		}
+		result[name] = value.String()
+	}
+	return result
+}
+
+func (c *digestChallenge) hash() (func() hash.Hash, error) {
+	switch strings.TrimSuffix(c.algorithm, "-SESS") {
+	case "MD5":
+		return md5.New, nil
+	case "SHA-256":
+		return sha256.New, nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm '%s'", c.algorithm)
+}
+
+// authorization returns the value of the authorization header for passed request data
+func (c *digestChallenge) authorization(user, password, method, uri string, nc uint32, cnonce string) string {
+	newHash, _ := c.hash()
+	h := func(s string) string {
+		hasher := newHash()
+		io.WriteString(hasher, s)
+		return hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	ha1 := h(user + ":" + c.realm + ":" + password)
+	if strings.HasSuffix(c.algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if c.qop == "" {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	} else {
+		response = h(fmt.Sprintf("%s:%s:%08x:%s:%s:%s", ha1, c.nonce, nc, cnonce, c.qop, ha2))
+	}
+
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s"`,
+		user, c.realm, c.nonce, uri, c.algorithm, response)
+	if len(c.opaque) > 0 {
+		authorization += fmt.Sprintf(`, opaque="%s"`, c.opaque)
+	}
+	if len(c.qop) > 0 {
+		authorization += fmt.Sprintf(`, qop=%s, nc=%08x, cnonce="%s"`, c.qop, nc, cnonce)
+	}
+	return authorization
+}
+
+// digestAuth caches the challenge of the box, so following requests are authenticated without an additional
+// round-trip. The nonce count is incremented with each request.
+type digestAuth struct {
+	user     string
+	password string
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint32
+}
+
+func newDigestAuth(user string, password string) *digestAuth {
+	return &digestAuth{
+		user:     user,
+		password: password,
+	}
+}
+
+// update replaces the cached challenge
+func (d *digestAuth) update(challenge *digestChallenge) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.challenge = challenge
+	d.nc = 0
+}
+
+// authorize sets the authorization header if a challenge is known, returns false otherwise
+func (d *digestAuth) authorize(req *http.Request) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.challenge == nil {
+		return false
+	}
+	d.nc++
+	req.Header.Set("Authorization", d.challenge.authorization(d.user, d.password, req.Method, req.URL.RequestURI(), d.nc, getCnonce()))
+	return true
+}
+
+func getCnonce() string {
+	b := make([]byte, 16)
+	io.ReadFull(rand.Reader, b)
+	return hex.EncodeToString(b)
+}
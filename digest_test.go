@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// example of RFC 7616 section 3.9.1
+const testChallenge = `realm="http-auth@example.org", qop="auth, auth-int", nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+
+func Test_parseDigestChallengePrefersSHA256(t *testing.T) {
+	challenge, err := parseDigestChallenge([]string{
+		`Digest ` + testChallenge + `, algorithm=MD5`,
+		`Digest ` + testChallenge + `, algorithm=SHA-256`,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "SHA-256", challenge.algorithm)
+	assert.Equal(t, "http-auth@example.org", challenge.realm)
+	assert.Equal(t, "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", challenge.nonce)
+	assert.Equal(t, "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", challenge.opaque)
+	assert.Equal(t, "auth", challenge.qop)
+	assert.False(t, challenge.stale)
+}
+
+func Test_parseDigestChallengeStale(t *testing.T) {
+	challenge, err := parseDigestChallenge([]string{`Digest realm="F!Box SOAP-Auth", nonce="4D3F6C0E", algorithm=MD5, qop="auth", stale=true`})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "F!Box SOAP-Auth", challenge.realm)
+	assert.True(t, challenge.stale)
+}
+
+func Test_parseDigestChallengeUnsupported(t *testing.T) {
+	_, err := parseDigestChallenge([]string{`Basic realm="box"`, `Digest realm="box", nonce="1", algorithm=SHA-512-256`})
+
+	assert.Error(t, err)
+}
+
+func Test_digestChallengeAuthorization(t *testing.T) {
+	for algorithm, response := range map[string]string{
+		"MD5":     "8ca523f5e9506fed4657c9700eebdbec",
+		"SHA-256": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	} {
+		challenge, err := parseDigestChallenge([]string{`Digest ` + testChallenge + `, algorithm=` + algorithm})
+		assert.NoError(t, err)
+
+		authorization := challenge.authorization("Mufasa", "Circle of Life", "GET", "/dir/index.html", 1, "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ")
+
+		assert.Contains(t, authorization, `response="`+response+`"`)
+		assert.Contains(t, authorization, `nc=00000001`)
+		assert.Contains(t, authorization, `opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`)
+	}
+}
+
+func Test_digestAuthIncrementsNonceCount(t *testing.T) {
+	sut := newDigestAuth("user", "password")
+	req, _ := http.NewRequest("GET", "http://box:49000/tr64desc.xml", nil)
+
+	assert.False(t, sut.authorize(req))
+
+	challenge, _ := parseDigestChallenge([]string{`Digest realm="box", nonce="abc", qop="auth"`})
+	sut.update(challenge)
+
+	assert.True(t, sut.authorize(req))
+	assert.True(t, strings.Contains(req.Header.Get("Authorization"), "nc=00000001"))
+	assert.True(t, sut.authorize(req))
+	assert.True(t, strings.Contains(req.Header.Get("Authorization"), "nc=00000002"))
+}
+
+func Test_digestPostReusesChallenge(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		if !strings.Contains(req.Header.Get("Authorization"), `nonce="abc"`) {
+			rw.Header().Set("WWW-Authenticate", `Digest realm="box", nonce="abc", qop="auth"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	sut := newDigestAuth("user", "password")
+
+	for i := 0; i < 2; i++ {
+		resp, err := digestPost(server.Client(), sut, newRequest(context.Background(), "POST", server.URL+"/upnp/control/deviceinfo", "body"))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// only the first request needs the additional round-trip
+	assert.Equal(t, 3, requests)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// web UI data pages of cable and fiber boxes
+const (
+	docsisPage = "docInfo"
+	fiberPage  = "fiberInfo"
+)
+
+// flexFloat is a number which the web UI delivers either as JSON number or as string. It stays unset for
+// placeholders of unavailable values (e.g. "---" for the MER of DOCSIS 3.0 channels).
+type flexFloat struct {
+	value float64
+	set   bool
+}
+
+func (f *flexFloat) UnmarshalJSON(b []byte) error {
+	*f = flexFloat{}
+	s := strings.Trim(string(b), `"`)
+	if v, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		*f = flexFloat{value: v, set: true}
+	}
+	return nil
+}
+
+type docsisChannel struct {
+	ChannelID     flexFloat `json:"channelID"`
+	Frequency     string    `json:"frequency"`
+	Type          string    `json:"type"`
+	Modulation    string    `json:"modulation"`
+	PowerLevel    flexFloat `json:"powerLevel"`
+	MSE           flexFloat `json:"mse"`
+	MER           flexFloat `json:"mer"`
+	CorrErrors    flexFloat `json:"corrErrors"`
+	NonCorrErrors flexFloat `json:"nonCorrErrors"`
+}
+
+func (c docsisChannel) modulation() string {
+	if len(c.Modulation) > 0 {
+		return c.Modulation
+	}
+	return c.Type
+}
+
+type docsisChannels struct {
+	Docsis30 []docsisChannel `json:"docsis30"`
+	Docsis31 []docsisChannel `json:"docsis31"`
+}
+
+type docsisInfo struct {
+	Downstream docsisChannels `json:"channelDs"`
+	Upstream   docsisChannels `json:"channelUs"`
+}
+
+// fiberInfo contains the optical power of the fiber module in dBm
+type fiberInfo struct {
+	RxPower flexFloat `json:"rxPower"`
+	TxPower flexFloat `json:"txPower"`
+}
+
+func parseDocsisInfo(data []byte) (*docsisInfo, error) {
+	var info docsisInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (collector *FritzBoxCollector) collectDocsis(ctx context.Context, ch chan<- prometheus.Metric) {
+	data, err := collector.webUI.Data(ctx, docsisPage)
+	if err != nil {
+		log.Warnf("can't read DOCSIS information: %v", err)
+		return
+	}
+	info, err := parseDocsisInfo(data)
+	if err != nil {
+		log.Debugf("no DOCSIS information available: %v", err)
+		return
+	}
+
+	for _, c := range []struct {
+		direction string
+		version   string
+		channels  []docsisChannel
+	}{
+		{"downstream", "3.0", info.Downstream.Docsis30},
+		{"downstream", "3.1", info.Downstream.Docsis31},
+		{"upstream", "3.0", info.Upstream.Docsis30},
+		{"upstream", "3.1", info.Upstream.Docsis31},
+	} {
+		for _, channel := range c.channels {
+			if !channel.ChannelID.set {
+				continue
+			}
+			id := strconv.FormatFloat(channel.ChannelID.value, 'f', -1, 64)
+
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_docsis_channel_info",
+				"DOCSIS channel information",
+				[]string{"direction", "docsis", "channel_id", "frequency", "modulation"},
+				nil,
+			), prometheus.GaugeValue, 1, c.direction, c.version, id, channel.Frequency, channel.modulation())
+
+			if channel.PowerLevel.set {
+				ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+					"fb_docsis_channel_power_dbmv",
+					"DOCSIS channel power level in dBmV",
+					[]string{"direction", "docsis", "channel_id"},
+					nil,
+				), prometheus.GaugeValue, channel.PowerLevel.value, c.direction, c.version, id)
+			}
+
+			if channel.MSE.set {
+				ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+					"fb_docsis_channel_mse_db",
+					"DOCSIS channel mean square error (MSE) in dB",
+					[]string{"direction", "docsis", "channel_id"},
+					nil,
+				), prometheus.GaugeValue, channel.MSE.value, c.direction, c.version, id)
+			}
+
+			if channel.MER.set {
+				ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+					"fb_docsis_channel_mer_db",
+					"DOCSIS channel modulation error ratio (MER) in dB",
+					[]string{"direction", "docsis", "channel_id"},
+					nil,
+				), prometheus.GaugeValue, channel.MER.value, c.direction, c.version, id)
+			}
+
+			if channel.CorrErrors.set {
+				ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+					"fb_docsis_channel_corrected_codewords_total",
+					"DOCSIS channel corrected codewords",
+					[]string{"direction", "docsis", "channel_id"},
+					nil,
+				), prometheus.CounterValue, channel.CorrErrors.value, c.direction, c.version, id)
+			}
+
+			if channel.NonCorrErrors.set {
+				ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+					"fb_docsis_channel_uncorrectable_codewords_total",
+					"DOCSIS channel uncorrectable codewords",
+					[]string{"direction", "docsis", "channel_id"},
+					nil,
+				), prometheus.CounterValue, channel.NonCorrErrors.value, c.direction, c.version, id)
+			}
+		}
+	}
+}
+
+func (collector *FritzBoxCollector) collectFiber(ctx context.Context, ch chan<- prometheus.Metric) {
+	data, err := collector.webUI.Data(ctx, fiberPage)
+	if err != nil {
+		log.Warnf("can't read fiber information: %v", err)
+		return
+	}
+	var info fiberInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		log.Debugf("no fiber information available: %v", err)
+		return
+	}
+
+	if info.RxPower.set {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_fiber_optical_rx_power_dbm",
+			"Fiber optical receive power in dBm",
+			nil,
+			nil,
+		), prometheus.GaugeValue, info.RxPower.value)
+	}
+
+	if info.TxPower.set {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_fiber_optical_tx_power_dbm",
+			"Fiber optical transmit power in dBm",
+			nil,
+			nil,
+		), prometheus.GaugeValue, info.TxPower.value)
+	}
+}
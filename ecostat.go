@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// web UI data page with the system load history
+const ecoStatPage = "ecoStat"
+
+// ecoStatSeries contains one or more series of values, the newest value is the last one
+type ecoStatSeries struct {
+	Series [][]flexFloat `json:"series"`
+}
+
+type ecoStat struct {
+	CPUTemperature ecoStatSeries `json:"cputemp"`
+	CPUUtilization ecoStatSeries `json:"cpuutil"`
+	// RAM usage has the series fixed, dynamic and free (in this order)
+	RAMUsage ecoStatSeries `json:"ramusage"`
+}
+
+// last returns the newest value of the series with passed index
+func (s ecoStatSeries) last(index int) (float64, bool) {
+	if index >= len(s.Series) || len(s.Series[index]) == 0 {
+		return 0, false
+	}
+	series := s.Series[index]
+	newest := series[len(series)-1]
+	return newest.value, newest.set
+}
+
+func (collector *FritzBoxCollector) collectEcoStat(ctx context.Context, ch chan<- prometheus.Metric) {
+	data, err := collector.webUI.Data(ctx, ecoStatPage)
+	if err != nil {
+		log.Warnf("can't read eco statistics: %v", err)
+		return
+	}
+	var stat ecoStat
+	if err := json.Unmarshal(data, &stat); err != nil {
+		log.Debugf("no eco statistics available: %v", err)
+		return
+	}
+
+	if temperature, ok := stat.CPUTemperature.last(0); ok {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_cpu_temperature_celsius",
+			"CPU temperature in degree celsius",
+			nil,
+			nil,
+		), prometheus.GaugeValue, temperature)
+	}
+
+	if load, ok := stat.CPUUtilization.last(0); ok {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_cpu_load_percent",
+			"CPU load in percent",
+			nil,
+			nil,
+		), prometheus.GaugeValue, load)
+	}
+
+	for i, usage := range []string{"fixed", "dynamic", "free"} {
+		if value, ok := stat.RAMUsage.last(i); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_ram_usage_percent",
+				"RAM usage in percent",
+				[]string{"usage"},
+				nil,
+			), prometheus.GaugeValue, value, usage)
+		}
+	}
+}
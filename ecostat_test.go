@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ecoStatSeriesLast(t *testing.T) {
+	for _, test := range []struct {
+		series   string
+		index    int
+		expected float64
+		ok       bool
+	}{
+		{`[[10, 20, 30]]`, 0, 30, true},
+		{`[["10", "20", "31.5"]]`, 0, 31.5, true},
+		{`[[10, 20], [40, 50], [5, 6]]`, 1, 50, true},
+		{`[[10, 20]]`, 1, 0, false},
+		{`[[]]`, 0, 0, false},
+		{`[]`, 0, 0, false},
+		{`[[10, "---"]]`, 0, 0, false},
+	} {
+		var s ecoStatSeries
+		assert.NoError(t, json.Unmarshal([]byte(`{"series": `+test.series+`}`), &s), test.series)
+
+		value, ok := s.last(test.index)
+
+		assert.Equal(t, test.expected, value, test.series)
+		assert.Equal(t, test.ok, ok, test.series)
+	}
+}
+
+func Test_collectEcoStat(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{"no eco statistics", `[]`, ""},
+		{
+			"CPU and RAM",
+			`{"cputemp": {"series": [[55, 57]]}, "cpuutil": {"series": [["12", "18"]]}, "ramusage": {"series": [[30, 31], [20, 22], [50, 47]]}}`,
+			`
+# HELP fb_cpu_load_percent CPU load in percent
+# TYPE fb_cpu_load_percent gauge
+fb_cpu_load_percent 18
+# HELP fb_cpu_temperature_celsius CPU temperature in degree celsius
+# TYPE fb_cpu_temperature_celsius gauge
+fb_cpu_temperature_celsius 57
+# HELP fb_ram_usage_percent RAM usage in percent
+# TYPE fb_ram_usage_percent gauge
+fb_ram_usage_percent{usage="dynamic"} 22
+fb_ram_usage_percent{usage="fixed"} 31
+fb_ram_usage_percent{usage="free"} 47
+`,
+		},
+		{
+			"box without temperature sensor",
+			`{"cputemp": {"series": []}, "cpuutil": {"series": [[5]]}}`,
+			`
+# HELP fb_cpu_load_percent CPU load in percent
+# TYPE fb_cpu_load_percent gauge
+fb_cpu_load_percent 5
+`,
+		},
+	} {
+		server, _ := newTestWebUI(func(sid string, page string) (int, string) {
+			return http.StatusOK, fmt.Sprintf(`{"sid": "%s", "data": %s}`, sid, test.data)
+		})
+		sut := newFritzBoxCollector(&Config{}, nil)
+		sut.webUI = NewWebUIClient(&Config{User: "user", Password: "secret"}, server.Client(), newAuthGuard())
+		sut.webUI.URL = server.URL
+
+		err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+			sut.collectEcoStat(context.Background(), ch)
+		}), strings.NewReader(test.expected))
+
+		assert.NoError(t, err, test.name)
+		server.Close()
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NetworkError is returned if the box is not reachable or the connection failed
+type NetworkError struct {
+	URL string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("can't call URL %s: %v", e.URL, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned if the box rejected the credentials or requests are suspended after failed
+// authentications (LockedUntil is set)
+type AuthError struct {
+	URL         string
+	LockedUntil time.Time
+}
+
+func (e *AuthError) Error() string {
+	if !e.LockedUntil.IsZero() {
+		return fmt.Sprintf("no request to URL %s after failed authentication until %s", e.URL, e.LockedUntil.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("authentication failed for URL %s, please check user name / password", e.URL)
+}
+
+// StatusError is returned on an unexpected HTTP status code
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed to call URL %s - status code was %d", e.URL, e.StatusCode)
+}
+
+// UPnP error codes of SOAP faults
+const (
+	upnpErrorInvalidAction        = 401
+	upnpErrorInvalidArgs          = 402
+	upnpErrorActionFailed         = 501
+	upnpErrorArgumentInvalid      = 600
+	upnpErrorValueOutOfRange      = 601
+	upnpErrorNotImplemented       = 602
+	upnpErrorOutOfMemory          = 603
+	upnpErrorNotAuthorized        = 606
+	upnpErrorSpecifiedArrayIndex  = 713
+	upnpErrorNoSuchEntry          = 714
+	upnpErrorInternalError        = 820
+	upnpErrorSecondFactorRequired = 866
+)
+
+var upnpErrorDescriptions = map[int]string{
+	upnpErrorInvalidAction:        "invalid action",
+	upnpErrorInvalidArgs:          "invalid arguments",
+	upnpErrorActionFailed:         "action failed",
+	upnpErrorArgumentInvalid:      "argument value invalid",
+	upnpErrorValueOutOfRange:      "argument value out of range",
+	upnpErrorNotImplemented:       "optional action not implemented",
+	upnpErrorOutOfMemory:          "out of memory",
+	upnpErrorNotAuthorized:        "action not authorized",
+	upnpErrorSpecifiedArrayIndex:  "specified array index invalid",
+	upnpErrorNoSuchEntry:          "no such entry in array",
+	upnpErrorInternalError:        "internal error",
+	upnpErrorSecondFactorRequired: "second factor authentication required",
+}
+
+// SOAPFaultError is returned if the box responded to an action with a SOAP fault, Code and Description are
+// taken from the UPnPError detail (Code is 0 if missing)
+type SOAPFaultError struct {
+	URL         string
+	FaultCode   string
+	FaultString string
+	Code        int
+	Description string
+}
+
+func (e *SOAPFaultError) Error() string {
+	if e.Code == 0 {
+		return fmt.Sprintf("SOAP fault on URL %s: %s (%s)", e.URL, e.FaultString, e.FaultCode)
+	}
+	description := e.Description
+	if known, ok := upnpErrorDescriptions[e.Code]; ok && !strings.EqualFold(known, description) {
+		if len(description) == 0 {
+			description = known
+		} else {
+			description = fmt.Sprintf("%s (%s)", description, known)
+		}
+	}
+	return fmt.Sprintf("UPnP error %d on URL %s: %s", e.Code, e.URL, description)
+}
+
+// errorCode returns the UPnP error code of a SOAP fault or an empty string for all other errors
+func errorCode(err error) string {
+	var soapFaultError *SOAPFaultError
+	if errors.As(err, &soapFaultError) && soapFaultError.Code > 0 {
+		return strconv.Itoa(soapFaultError.Code)
+	}
+	return ""
+}
+
+// ParseError is returned if a response of the box can't be parsed
+type ParseError struct {
+	URL string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("can't parse response of URL %s: %v", e.URL, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ActionError is the error of a single action of a service
+type ActionError struct {
+	ServiceType string
+	Action      string
+	Err         error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("%s/%s: %v", e.ServiceType, e.Action, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidCallError is returned by Call if the service or action doesn't exist or the arguments don't match the
+// service description
+type InvalidCallError struct {
+	ServiceType string
+	Action      string
+	Reason      string
+}
+
+func (e *InvalidCallError) Error() string {
+	return fmt.Sprintf("invalid call of %s/%s: %s", e.ServiceType, e.Action, e.Reason)
+}
+
+// ActionErrors is returned by Execute if some actions failed, the values of all other actions are valid
+type ActionErrors []*ActionError
+
+func (e ActionErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d action(s) failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// errorKind returns a short name of the error type, used as metric label
+func errorKind(err error) string {
+	var networkError *NetworkError
+	var authError *AuthError
+	var statusError *StatusError
+	var soapFaultError *SOAPFaultError
+	var parseError *ParseError
+	var invalidCallError *InvalidCallError
+	switch {
+	case errors.As(err, &networkError):
+		return "network"
+	case errors.As(err, &authError):
+		return "auth"
+	case errors.As(err, &soapFaultError):
+		return "soap_fault"
+	case errors.As(err, &statusError):
+		return "status"
+	case errors.As(err, &parseError):
+		return "parse"
+	case errors.As(err, &invalidCallError):
+		return "invalid_call"
+	}
+	return "unknown"
+}
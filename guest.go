@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// guestWLANIndex returns the index of the guest WLANConfiguration instance or 0 if there is none. Only the AP type
+// identifies the guest network, tri-band boxes have 3 or 4 instances without guest network.
+func guestWLANIndex(values []serviceActionValue) int {
+	for i := 1; i <= 4; i++ {
+		service := fmt.Sprintf("WLANConfiguration:%d", i)
+		if strings.Contains(strings.ToLower(filterByService(values, service, "X_AVM-DE_GetWLANExtInfo", "X_AVM-DE_APType")), "guest") {
+			return i
+		}
+	}
+	return 0
+}
+
+func (collector *FritzBoxCollector) collectGuest(ctx context.Context, ch chan<- prometheus.Metric, uPnPClient *UPnPClient, values []serviceActionValue) {
+	for _, udn := range devicesOf(values, "WLANConfiguration") {
+		lan := ofDevice(values, udn)
+		if index := guestWLANIndex(lan); index > 0 {
+			service := fmt.Sprintf("WLANConfiguration:%d", index)
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_guest_wlan_enabled",
+				"Guest WLAN is enabled",
+				[]string{"udn", "ssid"},
+				nil,
+			), prometheus.GaugeValue, filterConvertByService(lan, service, "GetInfo", "Enable"), udn, filterByService(lan, service, "GetInfo", "SSID"))
+		}
+	}
+
+	for _, udn := range devicesOf(values, "WANCommonInterfaceConfig") {
+		collector.collectOnlineMonitor(ctx, ch, uPnPClient, udn)
+	}
+}
+
+// collectOnlineMonitor exports the newest rates of the online monitor of passed WAN device
+func (collector *FritzBoxCollector) collectOnlineMonitor(ctx context.Context, ch chan<- prometheus.Metric, uPnPClient *UPnPClient, udn string) {
+	monitor, err := uPnPClient.CallDevice(ctx, udn, "WANCommonInterfaceConfig", "X_AVM-DE_GetOnlineMonitor", map[string]string{
+		"NewSyncGroupIndex": "0",
+	})
+	if err != nil {
+		// boxes without online monitor fail with an invalid call, which isn't counted
+		collector.countError(err)
+		return
+	}
+	currentRate := prometheus.NewDesc(
+		"fb_online_monitor_current_bps",
+		"Online monitor current rate as reported by the FritzBox (newest sample)",
+		[]string{"udn", "direction", "network"},
+		nil,
+	)
+	for name, samples := range monitor {
+		if !strings.HasSuffix(name, "current_bps") {
+			continue
+		}
+		network := "all"
+		if strings.Contains(name, "guest") {
+			network = "guest"
+		}
+		direction := ""
+		switch {
+		case strings.Contains(name, "ds_"):
+			direction = "downstream"
+		case strings.Contains(name, "us_"):
+			direction = "upstream"
+		default:
+			// multicast rates
+			continue
+		}
+		// comma separated samples, newest first
+		ch <- prometheus.MustNewConstMetric(currentRate, prometheus.GaugeValue, extract(strings.Split(samples, ",")[0]), udn, direction, network)
+	}
+}
+
+// collectGuestHosts counts the active hosts in the guest network by interface type, "Ethernet" for the guest LAN and
+// "802.11" for the guest WLAN. The box doesn't report whether the guest LAN is enabled.
+func (collector *FritzBoxCollector) collectGuestHosts(ch chan<- prometheus.Metric, hosts *hostList) {
+	active := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, h := range hosts.Hosts {
+		if h.Guest != "1" || h.Active != "1" || seen[h.MACAddress] {
+			continue
+		}
+		seen[h.MACAddress] = true
+		active[h.InterfaceType]++
+	}
+
+	for interfaceType, count := range active {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_guest_hosts_active",
+			"Number of active hosts in the guest network",
+			[]string{"interface"},
+			nil,
+		), prometheus.GaugeValue, count, interfaceType)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func wlanValues(ssids ...string) []serviceActionValue {
+	var values []serviceActionValue
+	for i, ssid := range ssids {
+		values = append(values, serviceActionValue{
+			serviceType: "urn:dslforum-org:service:WLANConfiguration:" + string(rune('1'+i)),
+			actionName:  "GetInfo",
+			variable:    "SSID",
+			value:       ssid,
+		})
+	}
+	return values
+}
+
+func Test_guestWLANIndexByAPType(t *testing.T) {
+	values := append(wlanValues("home", "home", "guest", "guest"), serviceActionValue{
+		serviceType: "urn:dslforum-org:service:WLANConfiguration:3",
+		actionName:  "X_AVM-DE_GetWLANExtInfo",
+		variable:    "X_AVM-DE_APType",
+		value:       "guest",
+	})
+
+	assert.Equal(t, 3, guestWLANIndex(values))
+}
+
+func Test_guestWLANIndexWithoutAPType(t *testing.T) {
+	// tri-band box (2.4, 5 and 6 GHz)
+	assert.Equal(t, 0, guestWLANIndex(wlanValues("home", "home", "home")))
+	assert.Equal(t, 0, guestWLANIndex(wlanValues("home", "home")))
+}
+
+func Test_collectGuestHosts(t *testing.T) {
+	hosts := &hostList{Hosts: []host{
+		{MACAddress: "AA:BB:CC:00:00:01", Active: "1", Guest: "1", InterfaceType: "802.11"},
+		{MACAddress: "AA:BB:CC:00:00:02", Active: "1", Guest: "1", InterfaceType: "802.11"},
+		{MACAddress: "AA:BB:CC:00:00:03", Active: "1", Guest: "1", InterfaceType: "Ethernet"},
+		// inactive, not in guest network and listed twice
+		{MACAddress: "AA:BB:CC:00:00:04", Active: "0", Guest: "1", InterfaceType: "Ethernet"},
+		{MACAddress: "AA:BB:CC:00:00:05", Active: "1", Guest: "0", InterfaceType: "Ethernet"},
+		{MACAddress: "AA:BB:CC:00:00:01", Active: "1", Guest: "1", InterfaceType: "802.11"},
+	}}
+	sut := newFritzBoxCollector(&Config{}, nil)
+
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		sut.collectGuestHosts(ch, hosts)
+	}), strings.NewReader(`
+# HELP fb_guest_hosts_active Number of active hosts in the guest network
+# TYPE fb_guest_hosts_active gauge
+fb_guest_hosts_active{interface="802.11"} 2
+fb_guest_hosts_active{interface="Ethernet"} 1
+`))
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// host filter (parental control) service, which reports the WAN access of a host by its IPv4 address
+const hostFilterService = "X_AVM-DE_HostFilter"
+
+// host is an entry of the host list, its path is provided by Hosts/X_AVM-DE_GetHostListPath
+type host struct {
+	IPAddress     string `xml:"IPAddress"`
+	MACAddress    string `xml:"MACAddress"`
+	Active        string `xml:"Active"`
+	HostName      string `xml:"HostName"`
+	InterfaceType string `xml:"InterfaceType"`
+	Port          string `xml:"X_AVM-DE_Port"`
+	Speed         string `xml:"X_AVM-DE_Speed"`
+	Guest         string `xml:"X_AVM-DE_Guest"`
+	WANAccess     string `xml:"X_AVM-DE_WANAccess"`
+	Disallow      string `xml:"X_AVM-DE_Disallow"`
+}
+
+type hostList struct {
+	Hosts []host `xml:"Item"`
+}
+
+func parseHostList(r io.Reader) (*hostList, error) {
+	var list hostList
+	if err := xml.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// HostList fetches and parses the list of known hosts from passed path (see Hosts/X_AVM-DE_GetHostListPath)
+func (uc *UPnPClient) HostList(ctx context.Context, path string) (*hostList, error) {
+	content, err := uc.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return parseHostList(content)
+}
+
+func (collector *FritzBoxCollector) collectHosts(ctx context.Context, ch chan<- prometheus.Metric, uPnPClient *UPnPClient, values []serviceActionValue) {
+	path := filterByService(values, "Hosts", "X_AVM-DE_GetHostListPath", "X_AVM-DE_HostListPath")
+	if len(path) == 0 {
+		return
+	}
+
+	hosts, err := uPnPClient.HostList(ctx, path)
+	if err != nil {
+		log.Warnf("can't parse host list: %v", err)
+		return
+	}
+
+	collector.queryHostFilter(ctx, uPnPClient, hosts)
+	collector.collectHostFilter(ch, hosts)
+	collector.collectHostLinks(ch, hosts)
+	collector.collectGuestHosts(ch, hosts)
+}
+
+// queryHostFilter updates the WAN access of the active hosts with IPv4 address from the host filter service, the
+// queries run concurrently with the configured number of concurrent requests. The host list values are kept for the
+// other hosts and if a query fails (e.g. boxes without host filter service).
+func (collector *FritzBoxCollector) queryHostFilter(ctx context.Context, uPnPClient *UPnPClient, hosts *hostList) {
+	var active []*host
+	for i := range hosts.Hosts {
+		h := &hosts.Hosts[i]
+		if h.Active == "1" && net.ParseIP(h.IPAddress).To4() != nil {
+			active = append(active, h)
+		}
+	}
+
+	uPnPClient.parallel(len(active), func(i int) {
+		access, err := uPnPClient.Call(ctx, hostFilterService, "GetWANAccessByIP", map[string]string{
+			"NewIPv4Address": active[i].IPAddress,
+		})
+		if err != nil {
+			collector.countError(err)
+			return
+		}
+		active[i].Disallow = access["NewDisallow"]
+		active[i].WANAccess = access["NewWANAccess"]
+	})
+}
+
+// wanAccessRestriction returns what restricts the WAN access of the host: "disallowed" if the internet access of the
+// host is blocked manually, "profile" if the access is denied without manual block, which the box only does because of
+// the access profile of the host (e.g. filter, time budget used up and no ticket redeemed), and "none" otherwise
+func (h host) wanAccessRestriction() string {
+	switch {
+	case h.Disallow == "1":
+		return "disallowed"
+	case h.WANAccess == "denied":
+		return "profile"
+	}
+	return "none"
+}
+
+// collectHostFilter exports the WAN access of the hosts, queried from the host filter for active hosts and taken from
+// the host list for all others
+func (collector *FritzBoxCollector) collectHostFilter(ch chan<- prometheus.Metric, hosts *hostList) {
+	seen := make(map[string]bool)
+	for _, h := range hosts.Hosts {
+		if len(h.MACAddress) == 0 || seen[h.MACAddress] {
+			continue
+		}
+		seen[h.MACAddress] = true
+
+		restriction := h.wanAccessRestriction()
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_host_wan_access_blocked",
+			"WAN access of the host is blocked (parental control, access profile or manually)",
+			[]string{"mac", "hostname"},
+			nil,
+		), prometheus.GaugeValue, boolToFloat(restriction != "none"), h.MACAddress, h.HostName)
+
+		if len(h.WANAccess) > 0 {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_host_wan_access_info",
+				"WAN access state of the host (granted, denied or error) and its restriction (disallowed, profile or none)",
+				[]string{"mac", "hostname", "state", "restriction"},
+				nil,
+			), prometheus.GaugeValue, 1, h.MACAddress, h.HostName, h.WANAccess, restriction)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseHostList(t *testing.T) {
+	hosts, err := parseHostList(strings.NewReader(`<?xml version="1.0" ?>
+<List>
+<Item>
+<Index>1</Index>
+<IPAddress>192.168.178.20</IPAddress>
+<MACAddress>AA:BB:CC:00:00:20</MACAddress>
+<Active>1</Active>
+<HostName>kids-tablet</HostName>
+<InterfaceType>802.11</InterfaceType>
+<X_AVM-DE_Port>0</X_AVM-DE_Port>
+<X_AVM-DE_Speed>0</X_AVM-DE_Speed>
+<X_AVM-DE_Guest>0</X_AVM-DE_Guest>
+<X_AVM-DE_WANAccess>denied</X_AVM-DE_WANAccess>
+<X_AVM-DE_Disallow>0</X_AVM-DE_Disallow>
+</Item>
+</List>`))
+
+	assert.NoError(t, err)
+	assert.Len(t, hosts.Hosts, 1)
+	assert.Equal(t, "kids-tablet", hosts.Hosts[0].HostName)
+	assert.Equal(t, "denied", hosts.Hosts[0].WANAccess)
+}
+
+func Test_collectHostFilter(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		host    host
+		blocked string
+		info    string
+	}{
+		{
+			"granted",
+			host{MACAddress: "AA:BB:CC:00:00:01", HostName: "laptop", WANAccess: "granted", Disallow: "0"},
+			`fb_host_wan_access_blocked{hostname="laptop",mac="AA:BB:CC:00:00:01"} 0`,
+			`fb_host_wan_access_info{hostname="laptop",mac="AA:BB:CC:00:00:01",restriction="none",state="granted"} 1`,
+		},
+		{
+			"denied by access profile",
+			host{MACAddress: "AA:BB:CC:00:00:02", HostName: "kids-tablet", WANAccess: "denied", Disallow: "0"},
+			`fb_host_wan_access_blocked{hostname="kids-tablet",mac="AA:BB:CC:00:00:02"} 1`,
+			`fb_host_wan_access_info{hostname="kids-tablet",mac="AA:BB:CC:00:00:02",restriction="profile",state="denied"} 1`,
+		},
+		{
+			"internet access blocked",
+			host{MACAddress: "AA:BB:CC:00:00:03", HostName: "console", WANAccess: "denied", Disallow: "1"},
+			`fb_host_wan_access_blocked{hostname="console",mac="AA:BB:CC:00:00:03"} 1`,
+			`fb_host_wan_access_info{hostname="console",mac="AA:BB:CC:00:00:03",restriction="disallowed",state="denied"} 1`,
+		},
+	} {
+		sut := newFritzBoxCollector(&Config{}, nil)
+
+		err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+			sut.collectHostFilter(ch, &hostList{Hosts: []host{test.host, test.host}})
+		}), strings.NewReader(fmt.Sprintf(`
+# HELP fb_host_wan_access_blocked WAN access of the host is blocked (parental control, access profile or manually)
+# TYPE fb_host_wan_access_blocked gauge
+%s
+# HELP fb_host_wan_access_info WAN access state of the host (granted, denied or error) and its restriction (disallowed, profile or none)
+# TYPE fb_host_wan_access_info gauge
+%s
+`, test.blocked, test.info)))
+
+		assert.NoError(t, err, test.name)
+	}
+}
+
+func Test_queryHostFilter(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		switch args["NewIPv4Address"] {
+		case "192.168.178.20":
+			return map[string]string{"NewDisallow": "0", "NewWANAccess": "denied"}, 0
+		case "192.168.178.21":
+			return map[string]string{"NewDisallow": "1", "NewWANAccess": "denied"}, 0
+		}
+		return nil, upnpErrorNoSuchEntry
+	})
+	defer box.Close()
+	sut := newFritzBoxCollector(&Config{}, box.client())
+	hosts := &hostList{Hosts: []host{
+		{IPAddress: "192.168.178.20", Active: "1", WANAccess: "granted", Disallow: "0"},
+		{IPAddress: "192.168.178.21", Active: "1", WANAccess: "granted", Disallow: "0"},
+		// inactive, IPv6 only and failed queries keep the values of the host list
+		{IPAddress: "192.168.178.22", Active: "0", WANAccess: "granted", Disallow: "0"},
+		{IPAddress: "fd00::1", Active: "1", WANAccess: "granted", Disallow: "0"},
+		{IPAddress: "192.168.178.23", Active: "1", WANAccess: "granted", Disallow: "0"},
+	}}
+
+	sut.queryHostFilter(context.Background(), sut.client, hosts)
+
+	var restrictions []string
+	for _, h := range hosts.Hosts {
+		restrictions = append(restrictions, h.wanAccessRestriction())
+	}
+	assert.Equal(t, []string{"profile", "disallowed", "none", "none", "none"}, restrictions)
+	assert.Equal(t, 3, box.requestCount("GetWANAccessByIP"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(sut.actionErrors.WithLabelValues("X_AVM-DE_HostFilter:1", "GetWANAccessByIP", "soap_fault", "714")))
+}
@@ -1,94 +1,157 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
-
-	"crypto/md5"
-	"crypto/rand"
-	"encoding/hex"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var dummy = http.Response{
-	Body: ioutil.NopCloser(bytes.NewBufferString("")),
+// retryBackoffBase is the upper bound of the first backoff before a retry, doubled with each further retry
+const retryBackoffBase = 200 * time.Millisecond
+
+// newHTTPClient creates a HTTP client with the configured connect and request timeouts, tlsConfig is optional
+func newHTTPClient(cfg *Config, tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: cfg.RequestTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   cfg.ConnectTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout: cfg.ConnectTimeout,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
 }
 
-func newRequest(method, uri, body string) *http.Request {
-	request, _ := http.NewRequest(method, uri, strings.NewReader(body))
+func newRequest(ctx context.Context, method, uri, body string) *http.Request {
+	request, _ := http.NewRequestWithContext(ctx, method, uri, strings.NewReader(body))
 
 	return request
 }
 
-func do(dr *http.Request, user string, password string) io.ReadCloser {
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+// retry calls f until it succeeds, fails with a permanent error or the number of retries is exhausted. Before each
+// retry it waits a random backoff (full jitter) with an exponentially growing upper bound.
+func retry(ctx context.Context, retries int, f func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	backoff := retryBackoffBase
+	for attempt := 0; ; attempt++ {
+		content, err := f()
+		if err == nil || attempt >= retries || !isTransient(err) || ctx.Err() != nil {
+			return content, err
+		}
 
-	resp := digestPost(dr, user, password)
-	var err error
+		wait := time.Duration(rand.Int63n(int64(backoff)))
+		log.Debugf("retrying in %v after error: %v", wait, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
 
+// isTransient returns true for network errors and server errors without SOAP fault, which may succeed on retry
+func isTransient(err error) bool {
+	var networkError *NetworkError
+	var statusError *StatusError
+	switch {
+	case errors.As(err, &networkError):
+		return true
+	case errors.As(err, &statusError):
+		return statusError.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// do sends the request and returns the response body, which must be closed by the caller
+func do(client *http.Client, auth *digestAuth, dr *http.Request) (io.ReadCloser, error) {
+	resp, err := digestPost(client, auth, dr)
 	if err != nil {
 		if err, ok := err.(net.Error); ok && err.Timeout() {
 			log.Warn(fmt.Sprintf("Timeout on calling URL %s", dr.URL))
-			return dummy.Body
-		} else {
-			log.Fatalln(err)
 		}
+		return nil, &NetworkError{URL: dr.URL.String(), Err: err}
 	}
-	if resp.StatusCode != http.StatusOK {
-		log.Warn(fmt.Sprintf("Failed to call URL %s - status code was %d", dr.URL, resp.StatusCode))
-		return dummy.Body
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusUnauthorized:
+		resp.Body.Close()
+		return nil, &AuthError{URL: dr.URL.String()}
+	case http.StatusInternalServerError:
+		// actions fail with a SOAP fault
+		defer resp.Body.Close()
+		if fault := parseSOAPFault(resp.Body, dr.URL.String()); fault != nil {
+			return nil, fault
+		}
+	default:
+		resp.Body.Close()
 	}
-	return resp.Body
+	return nil, &StatusError{URL: dr.URL.String(), StatusCode: resp.StatusCode}
 }
 
-func digestPost(req *http.Request, user string, password string) *http.Response {
-	client := &http.Client{}
+// digestPost sends the request, authenticated with the cached digest challenge if there is one. On a new
+// challenge (unknown or stale nonce) the request is sent once again.
+func digestPost(client *http.Client, auth *digestAuth, req *http.Request) (*http.Response, error) {
+	authorized := auth.authorize(req)
 	resp, err := client.Do(req)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	if resp.StatusCode != http.StatusUnauthorized {
-		log.Debugf("Recieved status code '%v' auth skipped", resp.StatusCode)
-		return resp
+		log.Debugf("Recieved status code '%v' (authorized: %t)", resp.StatusCode, authorized)
+		return resp, nil
 	}
 
-	defer resp.Body.Close()
-	digestParts := digestParts(resp)
-	digestParts["uri"] = req.URL.Path
-	digestParts["method"] = req.Method
-	digestParts["username"] = user
-	digestParts["password"] = password
-	body, _ := req.GetBody()
-	l := req.ContentLength
-	action := req.Header.Get("soapaction")
-	req, err = http.NewRequest(req.Method, req.URL.String(), body)
-	req.ContentLength = l
-
-	req.Header.Set("Authorization", getDigestAuthrization(digestParts))
-	req.Header.Set("Content-Type", "text/xml")
-	req.Header.Set("Charset", "utf-8")
-	req.Header.Set("soapaction", action)
-
-	resp, err = client.Do(req)
+	challenge, err := parseDigestChallenge(resp.Header["Www-Authenticate"])
 	if err != nil {
-		panic(err)
+		log.Warnf("can't authenticate: %v", err)
+		return resp, nil
 	}
-	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			panic(err)
-		}
-		log.Println("response body: ", string(body))
-		return resp
+	resp.Body.Close()
+	if authorized && !challenge.stale {
+		log.Debug("digest challenge was not accepted, retrying with new challenge")
+	}
+	auth.update(challenge)
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, _ = req.GetBody()
+	}
+	auth.authorize(retry)
+
+	return client.Do(retry)
+}
+
+// parseSOAPFault returns the SOAP fault of the response body or nil if the body is no SOAP fault
+func parseSOAPFault(r io.Reader, url string) *SOAPFaultError {
+	values := decodeResponse(r)
+	if _, ok := values["faultcode"]; !ok {
+		return nil
+	}
+	code, _ := strconv.Atoi(strings.TrimSpace(values["errorCode"]))
+	return &SOAPFaultError{
+		URL:         url,
+		FaultCode:   values["faultcode"],
+		FaultString: values["faultstring"],
+		Code:        code,
+		Description: strings.TrimSpace(values["errorDescription"]),
 	}
-	return resp
 }
 
 func formatRequest(r *http.Request) string {
@@ -116,44 +179,3 @@ func formatRequest(r *http.Request) string {
 	// Return the request as a string
 	return strings.Join(request, "\n")
 }
-
-func digestParts(resp *http.Response) map[string]string {
-	result := map[string]string{}
-	if len(resp.Header["Www-Authenticate"]) > 0 {
-		wantedHeaders := []string{"nonce", "realm", "qop"}
-		responseHeaders := strings.Split(resp.Header["Www-Authenticate"][0], ",")
-		for _, r := range responseHeaders {
-			for _, w := range wantedHeaders {
-				if strings.Contains(r, w) {
-					result[w] = strings.Split(r, `"`)[1]
-				}
-			}
-		}
-	}
-	return result
-}
-
-func getMD5(text string) string {
-	hasher := md5.New()
-	hasher.Write([]byte(text))
-	return hex.EncodeToString(hasher.Sum(nil))
-}
-
-func getCnonce() string {
-	b := make([]byte, 8)
-	io.ReadFull(rand.Reader, b)
-	return fmt.Sprintf("%x", b)[:16]
-}
-
-func getDigestAuthrization(digestParts map[string]string) string {
-	d := digestParts
-	ha1 := getMD5(d["username"] + ":" + d["realm"] + ":" + d["password"])
-	ha2 := getMD5(d["method"] + ":" + d["uri"])
-	nonceCount := 00000000
-	cnonce := getCnonce()
-
-	response := getMD5(fmt.Sprintf("%s:%s:%v:%s:%s:%s", ha1, d["nonce"], nonceCount, cnonce, d["qop"], ha2))
-	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s", qop="%s", nc="%v", cnonce="%s", algorithm=MD5`,
-		d["username"], d["realm"], d["nonce"], d["uri"], response, d["qop"], nonceCount, cnonce)
-	return authorization
-}
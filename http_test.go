@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSOAPFault = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body>
+<s:Fault>
+<faultcode>s:Client</faultcode>
+<faultstring>UPnPError</faultstring>
+<detail>
+<UPnPError xmlns="urn:dslforum-org:control-1-0">
+<errorCode>401</errorCode>
+<errorDescription>Invalid Action</errorDescription>
+</UPnPError>
+</detail>
+</s:Fault>
+</s:Body>
+</s:Envelope>`
+
+func Test_doErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/fault":
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(testSOAPFault))
+		case "/unauthorized":
+			rw.Header().Set("WWW-Authenticate", `Digest realm="box", nonce="abc", qop="auth"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for path, kind := range map[string]string{
+		"/fault":        "soap_fault",
+		"/unauthorized": "auth",
+		"/missing":      "status",
+	} {
+		_, err := do(server.Client(), newDigestAuth("user", "password"), newRequest(context.Background(), "POST", server.URL+path, ""))
+
+		assert.Error(t, err)
+		assert.Equal(t, kind, errorKind(err), path)
+	}
+
+	_, err := do(server.Client(), newDigestAuth("user", "password"), newRequest(context.Background(), "GET", "http://127.0.0.1:1/", ""))
+	assert.Equal(t, "network", errorKind(err))
+}
+
+func Test_parseSOAPFault(t *testing.T) {
+	fault := parseSOAPFault(strings.NewReader(testSOAPFault), "http://box:49000/upnp/control/hosts")
+
+	assert.Equal(t, "s:Client", fault.FaultCode)
+	assert.Equal(t, "UPnPError", fault.FaultString)
+	assert.Equal(t, 401, fault.Code)
+	assert.Equal(t, "Invalid Action", fault.Description)
+	assert.Equal(t, "401", errorCode(fault))
+	assert.Equal(t, "UPnP error 401 on URL http://box:49000/upnp/control/hosts: Invalid Action", fault.Error())
+
+	assert.Nil(t, parseSOAPFault(strings.NewReader("<html>internal error</html>"), ""))
+}
+
+func Test_soapFaultErrorDescription(t *testing.T) {
+	err := &SOAPFaultError{URL: "url", Code: 714, Description: "NoSuchEntryInArray"}
+
+	assert.Equal(t, "UPnP error 714 on URL url: NoSuchEntryInArray (no such entry in array)", err.Error())
+}
+
+func Test_invokeRetriesGetActions(t *testing.T) {
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		action := req.Header.Get("SoapAction")
+		requests[action]++
+		if requests[action] < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write([]byte("<s:Envelope></s:Envelope>"))
+	}))
+	defer server.Close()
+
+	sut, _ := NewUPnPClient(&Config{Retries: 2})
+	sut.URL = server.URL
+	service := Service{ServiceType: "urn:dslforum-org:service:DeviceInfo:1", ControlURL: "/upnp/control/deviceinfo"}
+
+	content, err := sut.invoke(context.Background(), service, Action{Name: "GetInfo"}, nil)
+	assert.NoError(t, err)
+	content.Close()
+	assert.Equal(t, 3, requests[service.ServiceType+"#GetInfo"])
+
+	// actions which change the box are not retried
+	_, err = sut.invoke(context.Background(), service, Action{Name: "Reboot"}, nil)
+	assert.Equal(t, "status", errorKind(err))
+	assert.Equal(t, 1, requests[service.ServiceType+"#Reboot"])
+}
+
+func Test_retryStopsOnPermanentError(t *testing.T) {
+	attempts := 0
+	_, err := retry(context.Background(), 2, func() (io.ReadCloser, error) {
+		attempts++
+		return nil, &AuthError{URL: "http://box"}
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
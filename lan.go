@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectLANInterface exports status, maximum bit rate and duplex mode of each LANEthernetInterfaceConfig instance of
+// passed LAN device. TR-064 provides one instance per ethernet interface, boxes with a built-in switch report all ports
+// as one instance.
+func (collector *FritzBoxCollector) collectLANInterface(ch chan<- prometheus.Metric, udn string, values []serviceActionValue) {
+	for i := 1; ; i++ {
+		service := fmt.Sprintf("LANEthernetInterfaceConfig:%d", i)
+		status := filterByService(values, service, "GetInfo", "Status")
+		if len(status) == 0 {
+			return
+		}
+
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_lan_eth_interface_info",
+			"LAN ethernet interface configuration",
+			[]string{"udn", "interface", "status", "max_bit_rate", "duplex"},
+			nil,
+		), prometheus.GaugeValue, 1,
+			udn,
+			strconv.Itoa(i),
+			status,
+			filterByService(values, service, "GetInfo", "MaxBitRate"),
+			filterByService(values, service, "GetInfo", "DuplexMode"))
+	}
+}
+
+// collectLANPorts exports link state and maximum data rate of the physical LAN ports (interfaces "LAN:<port>" of the
+// mesh master). Peers are identified by their mesh node uid, since device names aren't unique.
+func (collector *FritzBoxCollector) collectLANPorts(ch chan<- prometheus.Metric, mesh *meshList) {
+	for _, n := range mesh.Nodes {
+		if n.MeshRole != "master" {
+			continue
+		}
+		for _, i := range n.Interfaces {
+			if i.Type != "LAN" || !strings.HasPrefix(i.Name, "LAN:") {
+				continue
+			}
+			port := strings.TrimPrefix(i.Name, "LAN:")
+
+			var up bool
+			seen := make(map[string]bool)
+			for _, l := range i.Links {
+				if l.State != "CONNECTED" {
+					continue
+				}
+				up = true
+
+				peer := l.Node2UID
+				if peer == n.UID {
+					peer = l.Node1UID
+				}
+				if seen[peer] {
+					continue
+				}
+				seen[peer] = true
+
+				desc := prometheus.NewDesc(
+					"fb_lan_port_max_data_rate_mbits",
+					"Maximum data rate of the link on the LAN port in Mbit/s",
+					[]string{"port", "peer_uid", "peer", "direction"},
+					nil,
+				)
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, l.MaxDataRateRx/1000, port, peer, mesh.nodeName(peer), "rx")
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, l.MaxDataRateTx/1000, port, peer, mesh.nodeName(peer), "tx")
+			}
+
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_lan_port_link_up",
+				"Link state of the LAN port",
+				[]string{"port"},
+				nil,
+			), prometheus.GaugeValue, boolToFloat(up), port)
+		}
+	}
+}
+
+// collectHostLinks exports the link speed of active ethernet hosts
+func (collector *FritzBoxCollector) collectHostLinks(ch chan<- prometheus.Metric, hosts *hostList) {
+	seen := make(map[string]bool)
+	for _, h := range hosts.Hosts {
+		if h.Active != "1" || h.InterfaceType != "Ethernet" || len(h.MACAddress) == 0 || seen[h.MACAddress] {
+			continue
+		}
+		seen[h.MACAddress] = true
+
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_host_lan_speed_mbits",
+			"Link speed of the ethernet host in Mbit/s",
+			[]string{"mac", "hostname", "port"},
+			nil,
+		), prometheus.GaugeValue, extract(h.Speed), h.MACAddress, h.HostName, h.Port)
+	}
+}
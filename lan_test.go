@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_collectLANInterface(t *testing.T) {
+	var values []serviceActionValue
+	for service, info := range map[string][]string{
+		"LANEthernetInterfaceConfig:1": {"Up", "1000", "Full"},
+		"LANEthernetInterfaceConfig:2": {"NoLink", "Auto", "Auto"},
+	} {
+		for i, variable := range []string{"Status", "MaxBitRate", "DuplexMode"} {
+			values = append(values, serviceActionValue{
+				device:      "uuid:lan",
+				serviceType: "urn:dslforum-org:service:" + service,
+				actionName:  "GetInfo",
+				variable:    variable,
+				value:       info[i],
+			})
+		}
+	}
+	sut := newFritzBoxCollector(&Config{}, nil)
+
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		sut.collectLANInterface(ch, "uuid:lan", values)
+	}), strings.NewReader(`
+# HELP fb_lan_eth_interface_info LAN ethernet interface configuration
+# TYPE fb_lan_eth_interface_info gauge
+fb_lan_eth_interface_info{duplex="Full",interface="1",max_bit_rate="1000",status="Up",udn="uuid:lan"} 1
+fb_lan_eth_interface_info{duplex="Auto",interface="2",max_bit_rate="Auto",status="NoLink",udn="uuid:lan"} 1
+`))
+
+	assert.NoError(t, err)
+}
+
+func Test_collectLANPorts(t *testing.T) {
+	link := func(uid, peer string, rx, tx float64) meshLink {
+		return meshLink{UID: uid, State: "CONNECTED", Node1UID: "n-1", Node2UID: peer, MaxDataRateRx: rx, MaxDataRateTx: tx}
+	}
+	mesh := &meshList{Nodes: []meshNode{
+		{UID: "n-1", DeviceName: "fritz.box", MeshRole: "master", Interfaces: []meshInterface{
+			// two peers behind a switch with the same device name
+			{Name: "LAN:1", Type: "LAN", Links: []meshLink{link("nl-1", "n-2", 1000000, 1000000), link("nl-2", "n-3", 100000, 100000)}},
+			{Name: "LAN:2", Type: "LAN"},
+			{Name: "AP:2G:0", Type: "WLAN", Links: []meshLink{link("nl-3", "n-4", 144000, 144000)}},
+		}},
+		{UID: "n-2", DeviceName: "printer"},
+		{UID: "n-3", DeviceName: "printer"},
+	}}
+	sut := newFritzBoxCollector(&Config{}, nil)
+
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		sut.collectLANPorts(ch, mesh)
+	}), strings.NewReader(`
+# HELP fb_lan_port_link_up Link state of the LAN port
+# TYPE fb_lan_port_link_up gauge
+fb_lan_port_link_up{port="1"} 1
+fb_lan_port_link_up{port="2"} 0
+# HELP fb_lan_port_max_data_rate_mbits Maximum data rate of the link on the LAN port in Mbit/s
+# TYPE fb_lan_port_max_data_rate_mbits gauge
+fb_lan_port_max_data_rate_mbits{direction="rx",peer="printer",peer_uid="n-2",port="1"} 1000
+fb_lan_port_max_data_rate_mbits{direction="tx",peer="printer",peer_uid="n-2",port="1"} 1000
+fb_lan_port_max_data_rate_mbits{direction="rx",peer="printer",peer_uid="n-3",port="1"} 100
+fb_lan_port_max_data_rate_mbits{direction="tx",peer="printer",peer_uid="n-3",port="1"} 100
+`))
+
+	assert.NoError(t, err)
+}
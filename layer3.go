@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// collectDefaultConnection exports the WAN connection used as default route, e.g. "1.WANPPPConnection.1"
+// and counts changes of it (e.g. failover from DSL to LTE)
+func (collector *FritzBoxCollector) collectDefaultConnection(ch chan<- prometheus.Metric, values []serviceActionValue) {
+	connection := filterByService(values, "Layer3Forwarding", "GetDefaultConnectionService", "DefaultConnectionService")
+	if len(connection) == 0 {
+		return
+	}
+
+	collector.mu.Lock()
+	if len(collector.defaultConnection) > 0 && collector.defaultConnection != connection {
+		log.Infof("default WAN connection changed from %s to %s", collector.defaultConnection, connection)
+		collector.defaultConnectionChanges++
+	}
+	collector.defaultConnection = connection
+	changes := collector.defaultConnectionChanges
+	collector.mu.Unlock()
+
+	// <connection device index>.<service type>.<service index>
+	connectionType := connection
+	if parts := strings.Split(connection, "."); len(parts) == 3 {
+		connectionType = parts[1]
+	}
+
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_wan_default_connection_info",
+		"WAN connection which is currently the default route",
+		[]string{"connection", "type"},
+		nil,
+	), prometheus.GaugeValue, 1, connection, connectionType)
+
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_wan_default_connection_changes_total",
+		"Number of changes of the default WAN connection since exporter start",
+		nil,
+		nil,
+	), prometheus.CounterValue, changes)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cool-down after failed authentication, doubled with each further failure
+const (
+	authBackoffBase = 30 * time.Second
+	authBackoffMax  = time.Hour
+)
+
+// authGuard stops all requests to the box after a failed authentication for an exponentially growing cool-down,
+// because the box itself blocks logins with growing delays (for all users)
+type authGuard struct {
+	mu sync.Mutex
+	// consecutive failures since the last successful request
+	failures    int
+	total       float64
+	lockedUntil time.Time
+	now         func() time.Time
+}
+
+func newAuthGuard() *authGuard {
+	return &authGuard{now: time.Now}
+}
+
+// check returns an AuthError while the cool-down is active
+func (g *authGuard) check(url string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.now().Before(g.lockedUntil) {
+		return &AuthError{URL: url, LockedUntil: g.lockedUntil}
+	}
+	return nil
+}
+
+func (g *authGuard) failure() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.failures++
+	g.total++
+
+	backoff := authBackoffBase
+	for i := 1; i < g.failures && backoff < authBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > authBackoffMax {
+		backoff = authBackoffMax
+	}
+	g.lockedUntil = g.now().Add(backoff)
+	log.Warnf("authentication failed %d time(s), no requests to the box for %v", g.failures, backoff)
+}
+
+func (g *authGuard) success() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.failures > 0 {
+		log.Info("authentication successful again")
+	}
+	g.failures = 0
+	g.lockedUntil = time.Time{}
+}
+
+// status returns the total number of failures and if the cool-down is active
+func (g *authGuard) status() (float64, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.total, g.now().Before(g.lockedUntil)
+}
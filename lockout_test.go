@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_authGuardBackoff(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sut := newAuthGuard()
+	sut.now = func() time.Time { return now }
+
+	assert.NoError(t, sut.check("url"))
+
+	sut.failure()
+	assert.Error(t, sut.check("url"))
+	assert.Equal(t, now.Add(30*time.Second), sut.lockedUntil)
+
+	sut.failure()
+	sut.failure()
+	assert.Equal(t, now.Add(2*time.Minute), sut.lockedUntil)
+
+	for i := 0; i < 20; i++ {
+		sut.failure()
+	}
+	assert.Equal(t, now.Add(time.Hour), sut.lockedUntil)
+
+	failures, lockedOut := sut.status()
+	assert.Equal(t, float64(23), failures)
+	assert.True(t, lockedOut)
+
+	// cool-down is over
+	now = now.Add(time.Hour)
+	assert.NoError(t, sut.check("url"))
+
+	sut.success()
+	sut.failure()
+	assert.Equal(t, now.Add(30*time.Second), sut.lockedUntil)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,7 +10,6 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -22,24 +22,52 @@ func main() {
 		log.Fatalf("Could not parse config: %v\n", err)
 	}
 
-	prometheus.MustRegister(newFritzBoxCollector(&config))
+	uPnPClient, err := NewUPnPClient(&config)
+	if err != nil {
+		log.Fatalf("Could not create UPnP client: %v\n", err)
+	}
+
+	collector := newFritzBoxCollector(&config, uPnPClient)
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	if config.PollInterval > 0 {
+		log.Infof("polling the box every %v in background", config.PollInterval)
+		go collector.pollInBackground(pollCtx)
+	}
 
 	log.Info("Server is starting...")
 
 	router := http.NewServeMux()
-	router.Handle("/metrics", promhttp.Handler())
+	router.Handle("/metrics", collector.handler(prometheus.DefaultGatherer))
 	router.HandleFunc("/all", func(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Add("Content-Type", "text/plain")
-		uPnPClient := NewUPnPClient(
-			&config,
-			make(map[string][]string),
-		)
-		values := uPnPClient.Execute()
+		values, err := uPnPClient.Execute(req.Context(), make(map[string][]string))
+		if _, partial := err.(ActionErrors); err != nil && !partial {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		fmt.Fprintf(rw, "service:::action/variable    =    value")
 		for _, v := range values {
 			fmt.Fprintf(rw, "%s:::%s/%s   =   %s\n", v.serviceType, v.actionName, v.variable, v.value)
 		}
 	})
+	router.HandleFunc("/mesh.json", func(rw http.ResponseWriter, req *http.Request) {
+		graph, err := loadMeshGraph(req.Context(), uPnPClient)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(graph)
+	})
+	router.HandleFunc("/mesh.dot", func(rw http.ResponseWriter, req *http.Request) {
+		graph, err := loadMeshGraph(req.Context(), uPnPClient)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Add("Content-Type", "text/vnd.graphviz")
+		graph.writeDot(rw)
+	})
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%v", 8080),
@@ -57,6 +85,7 @@ func main() {
 	go func() {
 		<-quit
 		log.Info("Server is shutting down...")
+		stopPolling()
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
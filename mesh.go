@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// meshList is the topology document of the mesh master, its path is provided by Hosts/X_AVM-DE_GetMeshListPath
+type meshList struct {
+	SchemaVersion string     `json:"schema_version"`
+	Nodes         []meshNode `json:"nodes"`
+}
+
+type meshNode struct {
+	UID                string          `json:"uid"`
+	DeviceName         string          `json:"device_name"`
+	DeviceModel        string          `json:"device_model"`
+	DeviceManufacturer string          `json:"device_manufacturer"`
+	FirmwareVersion    string          `json:"device_firmware_version"`
+	MACAddress         string          `json:"device_mac_address"`
+	IsMeshed           bool            `json:"is_meshed"`
+	MeshRole           string          `json:"mesh_role"`
+	Interfaces         []meshInterface `json:"node_interfaces"`
+}
+
+type meshInterface struct {
+	UID            string `json:"uid"`
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	MACAddress     string `json:"mac_address"`
+	SSID           string `json:"ssid"`
+	OpMode         string `json:"opmode"`
+	CurrentChannel int    `json:"current_channel"`
+	// utilization of the current channel in percent
+	ChannelUtilization float64 `json:"channel_utilization"`
+	CurrentChannelInfo struct {
+		ChannelWidth float64 `json:"channel_width"`
+	} `json:"current_channel_info"`
+	Links []meshLink `json:"node_links"`
+}
+
+// meshLink describes a connection between two node interfaces, data rates are in kbit/s
+type meshLink struct {
+	UID               string  `json:"uid"`
+	Type              string  `json:"type"`
+	State             string  `json:"state"`
+	Node1UID          string  `json:"node_1_uid"`
+	Node2UID          string  `json:"node_2_uid"`
+	Interface1UID     string  `json:"node_interface_1_uid"`
+	Interface2UID     string  `json:"node_interface_2_uid"`
+	MaxDataRateRx     float64 `json:"max_data_rate_rx"`
+	MaxDataRateTx     float64 `json:"max_data_rate_tx"`
+	CurDataRateRx     float64 `json:"cur_data_rate_rx"`
+	CurDataRateTx     float64 `json:"cur_data_rate_tx"`
+	CurAvailabilityRx float64 `json:"cur_availability_rx"`
+	CurAvailabilityTx float64 `json:"cur_availability_tx"`
+}
+
+func parseMeshList(r io.Reader) (*meshList, error) {
+	var list meshList
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// node returns the node with passed uid or nil
+func (m *meshList) node(uid string) *meshNode {
+	for i := range m.Nodes {
+		if m.Nodes[i].UID == uid {
+			return &m.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// links returns all links of the topology, each link only once
+func (m *meshList) links() []meshLink {
+	var result []meshLink
+	seen := make(map[string]bool)
+	for _, n := range m.Nodes {
+		for _, i := range n.Interfaces {
+			for _, l := range i.Links {
+				if seen[l.UID] {
+					continue
+				}
+				seen[l.UID] = true
+				result = append(result, l)
+			}
+		}
+	}
+	return result
+}
+
+func (m *meshList) nodeName(uid string) string {
+	if n := m.node(uid); n != nil {
+		return n.DeviceName
+	}
+	return uid
+}
+
+// MeshList fetches and parses the mesh topology from passed path (see Hosts/X_AVM-DE_GetMeshListPath)
+func (uc *UPnPClient) MeshList(ctx context.Context, path string) (*meshList, error) {
+	content, err := uc.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return parseMeshList(content)
+}
+
+func (collector *FritzBoxCollector) collectMesh(ctx context.Context, ch chan<- prometheus.Metric, uPnPClient *UPnPClient, values []serviceActionValue) {
+	path := filterByService(values, "Hosts", "X_AVM-DE_GetMeshListPath", "X_AVM-DE_MeshListPath")
+	if len(path) == 0 {
+		return
+	}
+
+	mesh, err := uPnPClient.MeshList(ctx, path)
+	if err != nil {
+		log.Warnf("can't parse mesh list: %v", err)
+		return
+	}
+
+	for _, n := range mesh.Nodes {
+		if !n.IsMeshed {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_mesh_node_info",
+			"Mesh node (FritzBox, repeater, powerline adapter) information",
+			[]string{"node", "model", "manufacturer", "firmware", "mac", "role"},
+			nil,
+		), prometheus.GaugeValue, 1, n.DeviceName, n.DeviceModel, n.DeviceManufacturer, n.FirmwareVersion, n.MACAddress, n.MeshRole)
+	}
+
+	for _, l := range mesh.links() {
+		if l.State != "CONNECTED" {
+			continue
+		}
+		node1 := mesh.nodeName(l.Node1UID)
+		node2 := mesh.nodeName(l.Node2UID)
+
+		currentDesc := prometheus.NewDesc(
+			"fb_mesh_link_current_data_rate_kbps",
+			"Mesh link current data rate in kbit/s",
+			[]string{"link", "node_1", "node_2", "type", "direction"},
+			nil,
+		)
+		ch <- prometheus.MustNewConstMetric(currentDesc, prometheus.GaugeValue, l.CurDataRateRx, l.UID, node1, node2, l.Type, "rx")
+		ch <- prometheus.MustNewConstMetric(currentDesc, prometheus.GaugeValue, l.CurDataRateTx, l.UID, node1, node2, l.Type, "tx")
+
+		maxDesc := prometheus.NewDesc(
+			"fb_mesh_link_max_data_rate_kbps",
+			"Mesh link maximum data rate in kbit/s",
+			[]string{"link", "node_1", "node_2", "type", "direction"},
+			nil,
+		)
+		ch <- prometheus.MustNewConstMetric(maxDesc, prometheus.GaugeValue, l.MaxDataRateRx, l.UID, node1, node2, l.Type, "rx")
+		ch <- prometheus.MustNewConstMetric(maxDesc, prometheus.GaugeValue, l.MaxDataRateTx, l.UID, node1, node2, l.Type, "tx")
+	}
+	collector.collectLANPorts(ch, mesh)
+	collector.collectWLANRadios(ch, mesh)
+}
+
+// meshGraph is a normalized representation of the mesh topology, used for visualization
+type meshGraph struct {
+	Nodes []meshGraphNode `json:"nodes"`
+	Links []meshGraphLink `json:"links"`
+}
+
+type meshGraphNode struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Model        string `json:"model,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Firmware     string `json:"firmware,omitempty"`
+	MAC          string `json:"mac,omitempty"`
+	Role         string `json:"role,omitempty"`
+	Meshed       bool   `json:"meshed"`
+}
+
+// meshGraphLink contains the link data rates in kbit/s
+type meshGraphLink struct {
+	ID        string  `json:"id"`
+	Source    string  `json:"source"`
+	Target    string  `json:"target"`
+	Type      string  `json:"type"`
+	Band      string  `json:"band,omitempty"`
+	CurRateRx float64 `json:"cur_rate_rx"`
+	CurRateTx float64 `json:"cur_rate_tx"`
+	MaxRateRx float64 `json:"max_rate_rx"`
+	MaxRateTx float64 `json:"max_rate_tx"`
+}
+
+// iface returns the node interface with passed uid or nil
+func (m *meshList) iface(uid string) *meshInterface {
+	for i := range m.Nodes {
+		for j := range m.Nodes[i].Interfaces {
+			if m.Nodes[i].Interfaces[j].UID == uid {
+				return &m.Nodes[i].Interfaces[j]
+			}
+		}
+	}
+	return nil
+}
+
+// band returns the WLAN band of an interface, derived from its name like "AP:5G:0"
+func (i *meshInterface) band() string {
+	parts := strings.Split(i.Name, ":")
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "2G":
+			return "2.4GHz"
+		case "5G":
+			return "5GHz"
+		case "6G":
+			return "6GHz"
+		}
+	}
+	return ""
+}
+
+// band returns the WLAN band of a link, derived from the names of its interfaces
+func (m *meshList) band(l meshLink) string {
+	for _, uid := range []string{l.Interface1UID, l.Interface2UID} {
+		if i := m.iface(uid); i != nil {
+			if band := i.band(); len(band) > 0 {
+				return band
+			}
+		}
+	}
+	return ""
+}
+
+func newMeshGraph(m *meshList) *meshGraph {
+	graph := &meshGraph{
+		Nodes: make([]meshGraphNode, 0, len(m.Nodes)),
+		Links: make([]meshGraphLink, 0),
+	}
+	for _, n := range m.Nodes {
+		graph.Nodes = append(graph.Nodes, meshGraphNode{
+			ID:           n.UID,
+			Name:         n.DeviceName,
+			Model:        n.DeviceModel,
+			Manufacturer: n.DeviceManufacturer,
+			Firmware:     n.FirmwareVersion,
+			MAC:          n.MACAddress,
+			Role:         n.MeshRole,
+			Meshed:       n.IsMeshed,
+		})
+	}
+	for _, l := range m.links() {
+		if l.State != "CONNECTED" {
+			continue
+		}
+		graph.Links = append(graph.Links, meshGraphLink{
+			ID:        l.UID,
+			Source:    l.Node1UID,
+			Target:    l.Node2UID,
+			Type:      l.Type,
+			Band:      m.band(l),
+			CurRateRx: l.CurDataRateRx,
+			CurRateTx: l.CurDataRateTx,
+			MaxRateRx: l.MaxDataRateRx,
+			MaxRateTx: l.MaxDataRateTx,
+		})
+	}
+	return graph
+}
+
+// writeDot renders the graph in Graphviz DOT format
+func (g *meshGraph) writeDot(w io.Writer) {
+	fmt.Fprintln(w, "graph mesh {")
+	for _, n := range g.Nodes {
+		shape := "ellipse"
+		if n.Meshed {
+			shape = "box"
+		}
+		label := n.Name
+		if len(n.Model) > 0 {
+			label += "\n" + n.Model
+		}
+		fmt.Fprintf(w, "  %s [label=%s shape=%s];\n", dotQuote(n.ID), dotQuote(label), shape)
+	}
+	for _, l := range g.Links {
+		label := strings.TrimSpace(fmt.Sprintf("%s %s", l.Type, l.Band))
+		label += fmt.Sprintf("\n%.0f/%.0f Mbit/s", l.CurRateTx/1000, l.MaxRateTx/1000)
+		fmt.Fprintf(w, "  %s -- %s [label=%s];\n", dotQuote(l.Source), dotQuote(l.Target), dotQuote(label))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+// loadMeshGraph queries the mesh list path and builds the topology graph
+func loadMeshGraph(ctx context.Context, uPnPClient *UPnPClient) (*meshGraph, error) {
+	values, err := uPnPClient.Execute(ctx,
+		map[string][]string{
+			"Hosts": {"X_AVM-DE_GetMeshListPath"},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	path := filterByService(values, "Hosts", "X_AVM-DE_GetMeshListPath", "X_AVM-DE_MeshListPath")
+	if len(path) == 0 {
+		return nil, errors.New("mesh list path not available")
+	}
+
+	mesh, err := uPnPClient.MeshList(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return newMeshGraph(mesh), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMeshList = `{
+  "schema_version": "5.1",
+  "nodes": [
+    {
+      "uid": "n-1",
+      "device_name": "fritz.box",
+      "device_model": "FRITZ!Box 7590",
+      "device_manufacturer": "AVM",
+      "device_firmware_version": "154.07.29",
+      "device_mac_address": "AA:BB:CC:00:00:01",
+      "is_meshed": true,
+      "mesh_role": "master",
+      "node_interfaces": [
+        {
+          "uid": "ni-1",
+          "name": "AP:5G:0",
+          "type": "WLAN",
+          "node_links": [
+            {
+              "uid": "nl-1",
+              "type": "WLAN",
+              "state": "CONNECTED",
+              "node_1_uid": "n-1",
+              "node_2_uid": "n-2",
+              "node_interface_1_uid": "ni-1",
+              "node_interface_2_uid": "ni-2",
+              "max_data_rate_rx": 866000,
+              "max_data_rate_tx": 866000,
+              "cur_data_rate_rx": 108000,
+              "cur_data_rate_tx": 97500
+            }
+          ]
+        }
+      ]
+    },
+    {
+      "uid": "n-2",
+      "device_name": "repeater",
+      "device_model": "FRITZ!Repeater 1200",
+      "device_manufacturer": "AVM",
+      "device_firmware_version": "184.07.27",
+      "is_meshed": true,
+      "mesh_role": "slave",
+      "node_interfaces": [
+        {
+          "uid": "ni-2",
+          "name": "UPLINK:5G:0",
+          "type": "WLAN",
+          "node_links": [
+            {
+              "uid": "nl-1",
+              "type": "WLAN",
+              "state": "CONNECTED",
+              "node_1_uid": "n-1",
+              "node_2_uid": "n-2",
+              "node_interface_1_uid": "ni-1",
+              "node_interface_2_uid": "ni-2",
+              "max_data_rate_rx": 866000,
+              "max_data_rate_tx": 866000,
+              "cur_data_rate_rx": 108000,
+              "cur_data_rate_tx": 97500
+            }
+          ]
+        }
+      ]
+    }
+  ]
+}`
+
+func Test_parseMeshList(t *testing.T) {
+	mesh, err := parseMeshList(strings.NewReader(testMeshList))
+
+	assert.NoError(t, err)
+	assert.Len(t, mesh.Nodes, 2)
+	assert.Equal(t, "154.07.29", mesh.Nodes[0].FirmwareVersion)
+
+	links := mesh.links()
+	assert.Len(t, links, 1)
+	assert.Equal(t, float64(97500), links[0].CurDataRateTx)
+	assert.Equal(t, "fritz.box", mesh.nodeName(links[0].Node1UID))
+	assert.Equal(t, "repeater", mesh.nodeName(links[0].Node2UID))
+	assert.Equal(t, "unknown", mesh.nodeName("unknown"))
+}
+
+func Test_parseMeshListInvalid(t *testing.T) {
+	_, err := parseMeshList(strings.NewReader("<html></html>"))
+
+	assert.Error(t, err)
+}
+
+func Test_newMeshGraph(t *testing.T) {
+	mesh, _ := parseMeshList(strings.NewReader(testMeshList))
+
+	graph := newMeshGraph(mesh)
+
+	assert.Len(t, graph.Nodes, 2)
+	assert.Len(t, graph.Links, 1)
+	assert.Equal(t, "5GHz", graph.Links[0].Band)
+	assert.Equal(t, "n-1", graph.Links[0].Source)
+	assert.Equal(t, "n-2", graph.Links[0].Target)
+
+	var dot strings.Builder
+	graph.writeDot(&dot)
+
+	assert.Contains(t, dot.String(), `"n-1" [label="fritz.box\nFRITZ!Box 7590" shape=box];`)
+	assert.Contains(t, dot.String(), `"n-1" -- "n-2" [label="WLAN 5GHz\n98/866 Mbit/s"];`)
+}
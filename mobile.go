@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// mobile WAN connection of LTE models and USB modems
+const mobileService = "X_AVM-DE_WANMobileConnection"
+
+func (collector *FritzBoxCollector) collectMobile(ch chan<- prometheus.Metric, values []serviceActionValue) {
+	status := filterByService(values, mobileService, "GetInfo", "Status")
+	if len(status) == 0 {
+		// box has no mobile WAN connection
+		return
+	}
+	accessTechnology := filterByService(values, mobileService, "GetInfoEx", "CurrentAccessTechnology")
+	operator := filterByService(values, mobileService, "GetInfoEx", "Operator")
+	cellID := filterByService(values, mobileService, "GetInfoEx", "CellIdentity")
+
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_wan_mobile_connection_info",
+		"WAN mobile connection information",
+		[]string{"status", "access_technology", "operator", "cell_id"},
+		nil,
+	), prometheus.GaugeValue, 1, status, accessTechnology, operator, cellID)
+
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_wan_mobile_connected",
+		"WAN mobile connection is established",
+		nil,
+		nil,
+	), prometheus.GaugeValue, boolToFloat(status == "Connected"))
+
+	for antenna, variable := range map[string]string{"0": "SignalRSRP0", "1": "SignalRSRP1"} {
+		if rsrp := filterByService(values, mobileService, "GetInfoEx", variable); len(rsrp) > 0 {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wan_mobile_signal_rsrp_dbm",
+				"WAN mobile reference signal received power (RSRP) in dBm",
+				[]string{"antenna"},
+				nil,
+			), prometheus.GaugeValue, extract(rsrp), antenna)
+		}
+	}
+
+	if rsrq := filterByService(values, mobileService, "GetInfoEx", "SignalRSRQ"); len(rsrq) > 0 {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_wan_mobile_signal_rsrq_db",
+			"WAN mobile reference signal received quality (RSRQ) in dB",
+			nil,
+			nil,
+		), prometheus.GaugeValue, extract(rsrq))
+	}
+
+	if sinr := filterByService(values, mobileService, "GetInfoEx", "SignalSINR"); len(sinr) > 0 {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_wan_mobile_signal_sinr_db",
+			"WAN mobile signal to interference plus noise ratio (SINR) in dB",
+			nil,
+			nil,
+		), prometheus.GaugeValue, extract(sinr))
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func mobileValues(variables map[string]string) []serviceActionValue {
+	var values []serviceActionValue
+	for name, value := range variables {
+		action := "GetInfoEx"
+		if name == "Status" {
+			action = "GetInfo"
+		}
+		values = append(values, serviceActionValue{
+			serviceType: "urn:dslforum-org:service:" + mobileService + ":1",
+			actionName:  action,
+			variable:    name,
+			value:       value,
+		})
+	}
+	return values
+}
+
+func Test_collectMobile(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		variables map[string]string
+		expected  string
+	}{
+		{"no mobile connection", nil, ""},
+		{
+			"connected with signal quality",
+			map[string]string{
+				"Status":                  "Connected",
+				"CurrentAccessTechnology": "LTE",
+				"Operator":                "Telekom.de",
+				"CellIdentity":            "26201-1234567",
+				"SignalRSRP0":             "-95",
+				"SignalRSRP1":             "-101",
+				"SignalRSRQ":              "-11",
+				"SignalSINR":              "7",
+			},
+			`
+# HELP fb_wan_mobile_connected WAN mobile connection is established
+# TYPE fb_wan_mobile_connected gauge
+fb_wan_mobile_connected 1
+# HELP fb_wan_mobile_connection_info WAN mobile connection information
+# TYPE fb_wan_mobile_connection_info gauge
+fb_wan_mobile_connection_info{access_technology="LTE",cell_id="26201-1234567",operator="Telekom.de",status="Connected"} 1
+# HELP fb_wan_mobile_signal_rsrp_dbm WAN mobile reference signal received power (RSRP) in dBm
+# TYPE fb_wan_mobile_signal_rsrp_dbm gauge
+fb_wan_mobile_signal_rsrp_dbm{antenna="0"} -95
+fb_wan_mobile_signal_rsrp_dbm{antenna="1"} -101
+# HELP fb_wan_mobile_signal_rsrq_db WAN mobile reference signal received quality (RSRQ) in dB
+# TYPE fb_wan_mobile_signal_rsrq_db gauge
+fb_wan_mobile_signal_rsrq_db -11
+# HELP fb_wan_mobile_signal_sinr_db WAN mobile signal to interference plus noise ratio (SINR) in dB
+# TYPE fb_wan_mobile_signal_sinr_db gauge
+fb_wan_mobile_signal_sinr_db 7
+`,
+		},
+		{
+			"disconnected USB modem without signal values",
+			map[string]string{"Status": "Disconnected"},
+			`
+# HELP fb_wan_mobile_connected WAN mobile connection is established
+# TYPE fb_wan_mobile_connected gauge
+fb_wan_mobile_connected 0
+# HELP fb_wan_mobile_connection_info WAN mobile connection information
+# TYPE fb_wan_mobile_connection_info gauge
+fb_wan_mobile_connection_info{access_technology="",cell_id="",operator="",status="Disconnected"} 1
+`,
+		},
+	} {
+		sut := newFritzBoxCollector(&Config{}, nil)
+		values := mobileValues(test.variables)
+
+		err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+			sut.collectMobile(ch, values)
+		}), strings.NewReader(test.expected))
+
+		assert.NoError(t, err, test.name)
+	}
+}
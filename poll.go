@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poll is a query of the box, its metrics are available when done is closed. The health metrics (e.g. fb_up, action
+// errors) describe the poll itself, the value metrics the box.
+type poll struct {
+	done   chan struct{}
+	health []prometheus.Metric
+	values []prometheus.Metric
+	// up is true if the box could be queried
+	up       bool
+	finished time.Time
+
+	// waiters is the number of scrapes waiting for the poll, guarded by pollMu. cancel stops the poll once all of
+	// them gave up.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// metrics returns the health and value metrics of the poll
+func (p *poll) metrics() []prometheus.Metric {
+	metrics := make([]prometheus.Metric, 0, len(p.health)+len(p.values))
+	return append(append(metrics, p.health...), p.values...)
+}
+
+// metrics returns the metrics to serve: of a new poll or, in background polling mode, of the last poll
+func (collector *FritzBoxCollector) metrics(ctx context.Context) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	if collector.Config.PollInterval > 0 {
+		metrics = collector.lastPoll()
+	} else {
+		metrics = collector.snapshot(ctx)
+	}
+
+	collector.pollMu.Lock()
+	var timestamp float64
+	if collector.lastUp != nil {
+		timestamp = float64(collector.lastUp.finished.Unix())
+	}
+	collector.pollMu.Unlock()
+
+	return append(metrics, prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_last_successful_poll_timestamp_seconds",
+		"Time of the last successful poll of the box as unix timestamp",
+		nil,
+		nil,
+	), prometheus.GaugeValue, timestamp))
+}
+
+// lastPoll returns the health metrics of the latest poll and the values of the last successful poll. Polls older than
+// the maximum staleness (e.g. if polls hang) are left out.
+func (collector *FritzBoxCollector) lastPoll() []prometheus.Metric {
+	collector.pollMu.Lock()
+	defer collector.pollMu.Unlock()
+
+	maxStaleness := collector.Config.MaxStaleness
+	fresh := func(p *poll) bool {
+		return p != nil && (maxStaleness <= 0 || time.Since(p.finished) <= maxStaleness)
+	}
+
+	var metrics []prometheus.Metric
+	if fresh(collector.latest) {
+		metrics = append(metrics, collector.latest.health...)
+	}
+	if fresh(collector.lastUp) {
+		metrics = append(metrics, collector.lastUp.values...)
+	}
+	return metrics
+}
+
+// pollInBackground polls the box each poll interval until passed context is done. Each poll is cancelled after the
+// poll interval at the latest.
+func (collector *FritzBoxCollector) pollInBackground(ctx context.Context) {
+	interval := collector.Config.PollInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		<-collector.startPoll(ctx, interval).done
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshot returns the metrics of a poll of the box. Concurrent scrapes (e.g. of several Prometheus replicas) share
+// the poll in flight instead of querying the box once again. The poll runs as long as a scrape waits for it (at most
+// the poll timeout), so it is bounded by the latest deadline of its scrapes. A scrape which gives up waiting gets the
+// health metrics with fb_up 0.
+func (collector *FritzBoxCollector) snapshot(ctx context.Context) []prometheus.Metric {
+	start := time.Now()
+
+	collector.pollMu.Lock()
+	p := collector.startPollLocked(context.Background(), collector.Config.PollTimeout)
+	p.waiters++
+	collector.pollMu.Unlock()
+	defer collector.leavePoll(p)
+
+	select {
+	case <-p.done:
+		return p.metrics()
+	case <-ctx.Done():
+		return gather(func(ch chan<- prometheus.Metric) {
+			collector.collectHealth(ch, false, start)
+		})
+	}
+}
+
+// leavePoll unregisters a waiting scrape and cancels the poll if no scrape waits for it anymore
+func (collector *FritzBoxCollector) leavePoll(p *poll) {
+	collector.pollMu.Lock()
+	defer collector.pollMu.Unlock()
+
+	p.waiters--
+	if p.waiters == 0 {
+		p.cancel()
+	}
+}
+
+// startPoll returns the poll in flight or starts a new one with passed parent context and timeout (0 = unlimited)
+func (collector *FritzBoxCollector) startPoll(parent context.Context, timeout time.Duration) *poll {
+	collector.pollMu.Lock()
+	defer collector.pollMu.Unlock()
+
+	return collector.startPollLocked(parent, timeout)
+}
+
+// startPollLocked is startPoll, the caller holds pollMu
+func (collector *FritzBoxCollector) startPollLocked(parent context.Context, timeout time.Duration) *poll {
+	if collector.inFlight != nil {
+		return collector.inFlight
+	}
+	p := &poll{done: make(chan struct{})}
+	collector.inFlight = p
+
+	ctx, cancel := context.WithCancel(parent)
+	p.cancel = cancel
+	go func() {
+		defer cancel()
+		if timeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+			defer cancelTimeout()
+		}
+		collector.run(ctx, p)
+	}()
+	return p
+}
+
+func (collector *FritzBoxCollector) run(ctx context.Context, p *poll) {
+	defer func() {
+		collector.pollMu.Lock()
+		collector.inFlight = nil
+		collector.latest = p
+		if p.up {
+			collector.lastUp = p
+		}
+		collector.pollMu.Unlock()
+		close(p.done)
+	}()
+
+	start := time.Now()
+	p.values = gather(func(ch chan<- prometheus.Metric) {
+		p.up = collector.collect(ctx, ch)
+	})
+	p.health = gather(func(ch chan<- prometheus.Metric) {
+		collector.collectHealth(ch, p.up, start)
+	})
+	p.finished = time.Now()
+}
+
+// gather returns the metrics sent by passed collect function
+func gather(collect func(ch chan<- prometheus.Metric)) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	collected := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		collected <- metrics
+	}()
+
+	collect(ch)
+	close(ch)
+	return <-collected
+}
@@ -1,50 +1,182 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
 
 type FritzBoxCollector struct {
-	Config     *Config
+	Config *Config
+	client *UPnPClient
+	// webUI is only set if web UI collectors are enabled
+	webUI *WebUIClient
+
+	pollMu sync.Mutex
+	// poll of the box in flight, shared by concurrent scrapes
+	inFlight *poll
+	// latest poll and latest successful poll, served in background polling mode
+	latest *poll
+	lastUp *poll
+
+	// mu protects the state of the last scrapes below
+	mu         sync.Mutex
 	lastValues map[string]float64
 	offsets    map[string]float64
+	// default WAN connection of the last scrape
+	defaultConnection        string
+	defaultConnectionChanges float64
+	// failed actions by service, action and error kind
+	actionErrors *prometheus.CounterVec
 }
 
-func newFritzBoxCollector(config *Config) *FritzBoxCollector {
-	return &FritzBoxCollector{
+func newFritzBoxCollector(config *Config, client *UPnPClient) *FritzBoxCollector {
+	collector := &FritzBoxCollector{
 		Config:     config,
+		client:     client,
 		lastValues: make(map[string]float64),
 		offsets:    make(map[string]float64),
+		actionErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fb_action_errors_total",
+			Help: "Number of failed actions by error kind and UPnP error code",
+		}, []string{"service", "action", "kind", "code"}),
 	}
+	if config.WebUI {
+		collector.webUI = NewWebUIClient(config, client.httpClient(), client.guard)
+	}
+	return collector
 }
 
 func (collector *FritzBoxCollector) Describe(ch chan<- *prometheus.Desc) {
 
 }
 
-func filterByService(in []serviceActionValue, service string, action string, variable string) string {
+func (collector *FritzBoxCollector) collectUp(ch chan<- prometheus.Metric, up bool) {
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_up",
+		"Box is reachable and responds to TR-064 requests",
+		nil,
+		nil,
+	), prometheus.GaugeValue, boolToFloat(up))
+}
+
+func (collector *FritzBoxCollector) collectAuth(ch chan<- prometheus.Metric) {
+	failures, lockedOut := collector.client.guard.status()
+
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_auth_failures_total",
+		"Number of failed authentications",
+		nil,
+		nil,
+	), prometheus.CounterValue, failures)
+
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_auth_locked_out",
+		"Requests to the box are suspended after failed authentication",
+		nil,
+		nil,
+	), prometheus.GaugeValue, boolToFloat(lockedOut))
+}
+
+func (collector *FritzBoxCollector) collectScrapeDuration(ch chan<- prometheus.Metric, start time.Time) {
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		"fb_scrape_duration_seconds",
+		"Duration of querying the box",
+		nil,
+		nil,
+	), prometheus.GaugeValue, time.Since(start).Seconds())
+}
+
+// countError logs the failed actions and increments their error counter, other errors are ignored
+func (collector *FritzBoxCollector) countError(err error) {
+	var actionErrors ActionErrors
+	var actionError *ActionError
+	switch {
+	case errors.As(err, &actionErrors):
+		for _, e := range actionErrors {
+			collector.countError(e)
+		}
+	case errors.As(err, &actionError):
+		log.Warnf("action %s of service %s failed: %v", actionError.Action, serviceName(actionError.ServiceType), actionError.Err)
+		collector.actionErrors.WithLabelValues(serviceName(actionError.ServiceType), actionError.Action, errorKind(actionError.Err), errorCode(actionError.Err)).Inc()
+	}
+}
+
+// serviceName returns the service type without URN prefix, e.g. "WLANConfiguration:1"
+func serviceName(serviceType string) string {
+	return strings.TrimPrefix(serviceType, "urn:dslforum-org:service:")
+}
+
+func filterValueByService(in []serviceActionValue, service string, action string, variable string) (serviceActionValue, bool) {
 	for _, a := range in {
 		if strings.Contains(a.serviceType, service) && a.actionName == action && a.variable == variable {
-			return a.value
+			return a, true
 		}
 	}
 	log.Debugf("value for service %s, action %s, variable %s not found", service, action, variable)
-	return ""
+	return serviceActionValue{}, false
+}
+
+// devicesOf returns the UDNs of all devices providing passed service in order of the values
+func devicesOf(in []serviceActionValue, service string) []string {
+	var devices []string
+	seen := make(map[string]bool)
+	for _, a := range in {
+		if strings.Contains(a.serviceType, service) && !seen[a.device] {
+			seen[a.device] = true
+			devices = append(devices, a.device)
+		}
+	}
+	return devices
+}
+
+// ofDevice returns the values of the services of passed device
+func ofDevice(in []serviceActionValue, udn string) []serviceActionValue {
+	var values []serviceActionValue
+	for _, a := range in {
+		if a.device == udn {
+			values = append(values, a)
+		}
+	}
+	return values
+}
+
+func filterByService(in []serviceActionValue, service string, action string, variable string) string {
+	value, _ := filterValueByService(in, service, action, variable)
+	return value.value
 }
 
+// filterConvertByService returns the value converted according to its data type, 0 if it is missing or not a number
 func filterConvertByService(in []serviceActionValue, service string, action string, variable string) float64 {
-	return extract(filterByService(in, service, action, variable))
+	value, _ := filterValueByService(in, service, action, variable)
+	f, _ := value.float()
+	return f
 }
 
-func (collector *FritzBoxCollector) filterConvertAndCorrectByService(in []serviceActionValue, service string, action string, variable string) float64 {
-	newValue := filterConvertByService(in, service, action, variable)
+// filterConvertAndCorrectByService returns the counter value corrected for resets of the box. Returns false if the
+// value is missing (e.g. the action failed) or not a number, the counter is left out then instead of exported as 0.
+func (collector *FritzBoxCollector) filterConvertAndCorrectByService(in []serviceActionValue, service string, action string, variable string) (float64, bool) {
+	value, ok := filterValueByService(in, service, action, variable)
+	if !ok {
+		return 0, false
+	}
+	newValue, ok := value.float()
+	if !ok {
+		return 0, false
+	}
 
-	key := fmt.Sprintf("%s/%s/%s", service, action, variable)
+	// counters of the same service on several devices are corrected independently
+	key := fmt.Sprintf("%s/%s/%s/%s", value.device, service, action, variable)
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
 
 	lastValue := collector.lastValues[key]
 
@@ -54,7 +186,7 @@ func (collector *FritzBoxCollector) filterConvertAndCorrectByService(in []servic
 	correctedValue := newValue - collector.offsets[key]
 	collector.lastValues[key] = newValue
 
-	return correctedValue
+	return correctedValue, true
 }
 
 func extract(val string) float64 {
@@ -65,117 +197,201 @@ func extract(val string) float64 {
 }
 
 func (collector *FritzBoxCollector) Collect(ch chan<- prometheus.Metric) {
-	uPnPClient := NewUPnPClient(
-		collector.Config,
+	for _, metric := range collector.metrics(context.Background()) {
+		ch <- metric
+	}
+}
+
+// collectHealth exports whether the box could be queried, the authentication state, the duration of the poll started
+// at passed time and the action errors and durations
+func (collector *FritzBoxCollector) collectHealth(ch chan<- prometheus.Metric, up bool, start time.Time) {
+	collector.collectUp(ch, up)
+	collector.collectAuth(ch)
+	collector.collectScrapeDuration(ch, start)
+	collector.client.actionDuration.Collect(ch)
+	collector.actionErrors.Collect(ch)
+}
+
+// collect queries the box and exports its values, all requests are cancelled with passed context (e.g. on scrape
+// timeout). Returns false if the box couldn't be queried.
+func (collector *FritzBoxCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) bool {
+	uPnPClient := collector.client
+
+	values, err := uPnPClient.Execute(ctx,
 		map[string][]string{
 			"WANCommonInterfaceConfig":   {"GetTotalBytesReceived", "GetTotalBytesSent", "GetTotalPacketsSent", "GetTotalPacketsReceived"},
 			"WANPPPConnection":           {"GetExternalIPAddress", "GetStatusInfo"},
-			"LANEthernetInterfaceConfig": {"GetStatistics"},
-			"WLANConfiguration":          {"GetInfo", "GetTotalAssociations", "GetStatistics"},
+			"LANEthernetInterfaceConfig": {"GetStatistics", "GetInfo"},
+			"WLANConfiguration":          {"GetInfo", "GetTotalAssociations", "GetStatistics", "X_AVM-DE_GetWLANExtInfo"},
+			"Hosts":                      {"X_AVM-DE_GetMeshListPath", "X_AVM-DE_GetHostListPath"},
+			mobileService:                {"GetInfo", "GetInfoEx"},
+			"Layer3Forwarding":           {"GetDefaultConnectionService"},
 		},
 	)
-	values := uPnPClient.Execute()
+	collector.countError(err)
+	if _, partial := err.(ActionErrors); err != nil && !partial {
+		log.Errorf("can't query box: %v", err)
+		return false
+	}
 
-	wanTotalBytesReceived := collector.filterConvertAndCorrectByService(values, "WANCommonInterfaceConfig", "GetTotalBytesReceived", "TotalBytesReceived")
+	// boxes with several WAN or LAN devices provide the services once per device, told apart by label udn
+	for _, udn := range devicesOf(values, "WANCommonInterfaceConfig") {
+		wan := ofDevice(values, udn)
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_wan_total_bytes_received",
-		"WAN total bytes received",
-		nil,
-		nil,
-	), prometheus.CounterValue, wanTotalBytesReceived)
+		if value, ok := collector.filterConvertAndCorrectByService(wan, "WANCommonInterfaceConfig", "GetTotalBytesReceived", "TotalBytesReceived"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wan_total_bytes_received",
+				"WAN total bytes received",
+				[]string{"udn"},
+				nil,
+			), prometheus.CounterValue, value, udn)
+		}
+
+		if value, ok := collector.filterConvertAndCorrectByService(wan, "WANCommonInterfaceConfig", "GetTotalBytesSent", "TotalBytesSent"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wan_total_bytes_sent",
+				"WAN total bytes sent",
+				[]string{"udn"},
+				nil,
+			), prometheus.CounterValue, value, udn)
+		}
 
-	wanTotalBytesSent := collector.filterConvertAndCorrectByService(values, "WANCommonInterfaceConfig", "GetTotalBytesSent", "TotalBytesSent")
+		if value, ok := collector.filterConvertAndCorrectByService(wan, "WANCommonInterfaceConfig", "GetTotalPacketsReceived", "TotalPacketsReceived"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wan_total_packets_received",
+				"WAN total packets received",
+				[]string{"udn"},
+				nil,
+			), prometheus.CounterValue, value, udn)
+		}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_wan_total_bytes_sent",
-		"WAN total bytes sent",
-		nil,
-		nil,
-	), prometheus.CounterValue, wanTotalBytesSent)
+		if value, ok := collector.filterConvertAndCorrectByService(wan, "WANCommonInterfaceConfig", "GetTotalPacketsSent", "TotalPacketsSent"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wan_total_packets_sent",
+				"WAN total packets sent",
+				[]string{"udn"},
+				nil,
+			), prometheus.CounterValue, value, udn)
+		}
+	}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_wan_total_packets_received",
-		"WAN total packets received",
-		nil,
-		nil,
-	), prometheus.CounterValue, collector.filterConvertAndCorrectByService(values, "WANCommonInterfaceConfig", "GetTotalPacketsReceived", "TotalPacketsReceived"))
+	for _, udn := range devicesOf(values, "WANPPPConnection") {
+		ppp := ofDevice(values, udn)
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_wan_total_packets_sent",
-		"WAN total packets sent",
-		nil,
-		nil,
-	), prometheus.CounterValue, collector.filterConvertAndCorrectByService(values, "WANCommonInterfaceConfig", "GetTotalPacketsSent", "TotalPacketsSent"))
+		externalIP := filterByService(ppp, "WANPPPConnection", "GetExternalIPAddress", "ExternalIPAddress")
+		connectionStatus := filterByService(ppp, "WANPPPConnection", "GetStatusInfo", "ConnectionStatus")
+		if uptime, ok := filterValueByService(ppp, "WANPPPConnection", "GetStatusInfo", "Uptime"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wanppp_status_uptime",
+				"WAN PPP uptime",
+				[]string{"udn", "ip", "status"},
+				nil,
+			), prometheus.CounterValue, extract(uptime.value), udn, externalIP, connectionStatus)
+		}
+	}
 
-	externalIP := filterByService(values, "WANPPPConnection", "GetExternalIPAddress", "ExternalIPAddress")
-	connectionStatus := filterByService(values, "WANPPPConnection", "GetStatusInfo", "ConnectionStatus")
-	uptime := filterByService(values, "WANPPPConnection", "GetStatusInfo", "Uptime")
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_wanppp_status_uptime",
-		"WAN PPP uptime",
-		[]string{"ip", "status"},
-		nil,
-	), prometheus.CounterValue, extract(uptime), externalIP, connectionStatus)
+	collector.collectMobile(ch, values)
+	collector.collectDefaultConnection(ch, values)
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_lan_eth_total_bytes_received",
-		"LAN ethernet total bytes received",
-		nil,
-		nil,
-	), prometheus.CounterValue, collector.filterConvertAndCorrectByService(values, "LANEthernetInterfaceConfig", "GetStatistics", "Stats.BytesReceived"))
+	for _, udn := range devicesOf(values, "LANEthernetInterfaceConfig") {
+		lan := ofDevice(values, udn)
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_lan_eth_total_bytes_sent",
-		"LAN ethernet total bytes sent",
-		nil,
-		nil,
-	), prometheus.CounterValue, collector.filterConvertAndCorrectByService(values, "LANEthernetInterfaceConfig", "GetStatistics", "Stats.BytesSent"))
+		if value, ok := collector.filterConvertAndCorrectByService(lan, "LANEthernetInterfaceConfig", "GetStatistics", "Stats.BytesReceived"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_lan_eth_total_bytes_received",
+				"LAN ethernet total bytes received",
+				[]string{"udn"},
+				nil,
+			), prometheus.CounterValue, value, udn)
+		}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_lan_eth_total_packets_received",
-		"LAN ethernet total packets received",
-		nil,
-		nil,
-	), prometheus.CounterValue, collector.filterConvertAndCorrectByService(values, "LANEthernetInterfaceConfig", "GetStatistics", "Stats.PacketsReceived"))
+		if value, ok := collector.filterConvertAndCorrectByService(lan, "LANEthernetInterfaceConfig", "GetStatistics", "Stats.BytesSent"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_lan_eth_total_bytes_sent",
+				"LAN ethernet total bytes sent",
+				[]string{"udn"},
+				nil,
+			), prometheus.CounterValue, value, udn)
+		}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"fb_lan_eth_total_packets_sent",
-		"LAN ethernet total packets sent",
-		nil,
-		nil,
-	), prometheus.CounterValue, collector.filterConvertAndCorrectByService(values, "LANEthernetInterfaceConfig", "GetStatistics", "Stats.PacketsSent"))
+		if value, ok := collector.filterConvertAndCorrectByService(lan, "LANEthernetInterfaceConfig", "GetStatistics", "Stats.PacketsReceived"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_lan_eth_total_packets_received",
+				"LAN ethernet total packets received",
+				[]string{"udn"},
+				nil,
+			), prometheus.CounterValue, value, udn)
+		}
 
+		if value, ok := collector.filterConvertAndCorrectByService(lan, "LANEthernetInterfaceConfig", "GetStatistics", "Stats.PacketsSent"); ok {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_lan_eth_total_packets_sent",
+				"LAN ethernet total packets sent",
+				[]string{"udn"},
+				nil,
+			), prometheus.CounterValue, value, udn)
+		}
+
+		collector.collectLANInterface(ch, udn, lan)
+	}
+
+	collector.collectDevices(ctx, ch, uPnPClient)
+	collector.collectStateSets(ch, values)
+
+	for _, udn := range devicesOf(values, "WLANConfiguration") {
+		collector.collectWLAN(ch, udn, ofDevice(values, udn))
+	}
+
+	collector.collectGuest(ctx, ch, uPnPClient, values)
+	collector.collectMesh(ctx, ch, uPnPClient, values)
+	collector.collectHosts(ctx, ch, uPnPClient, values)
+
+	if collector.webUI != nil {
+		collector.collectDocsis(ctx, ch)
+		collector.collectFiber(ctx, ch)
+		collector.collectEcoStat(ctx, ch)
+		collector.collectWLANEnvironment(ctx, ch)
+	}
+	return true
+}
+
+// collectWLAN exports the WLAN metrics of the WLANConfiguration instances of passed LAN device
+func (collector *FritzBoxCollector) collectWLAN(ch chan<- prometheus.Metric, udn string, values []serviceActionValue) {
+	guestIndex := guestWLANIndex(values)
 	for i := 1; i <= 4; i++ {
+		guest := fmt.Sprintf("%t", i == guestIndex)
 		wlanName := filterByService(values, fmt.Sprintf("WLANConfiguration:%d", i), "GetInfo", "SSID")
 		wlanStandard := filterByService(values, fmt.Sprintf("WLANConfiguration:%d", i), "GetInfo", "Standard")
 		wlanNameStandard := fmt.Sprintf("%d:%s (%s)", i, wlanName, wlanStandard)
 		totalAssociations := filterConvertByService(values, fmt.Sprintf("WLANConfiguration:%d", i), "GetTotalAssociations", "TotalAssociations")
-		totalPacketsSent := collector.filterConvertAndCorrectByService(values, fmt.Sprintf("WLANConfiguration:%d", i), "GetStatistics", "TotalPacketsSent")
-		totalPacketsReceived := collector.filterConvertAndCorrectByService(values, fmt.Sprintf("WLANConfiguration:%d", i), "GetStatistics", "TotalPacketsReceived")
+		totalPacketsSent, sentOK := collector.filterConvertAndCorrectByService(values, fmt.Sprintf("WLANConfiguration:%d", i), "GetStatistics", "TotalPacketsSent")
+		totalPacketsReceived, receivedOK := collector.filterConvertAndCorrectByService(values, fmt.Sprintf("WLANConfiguration:%d", i), "GetStatistics", "TotalPacketsReceived")
 
 		if len(wlanName) > 0 {
 			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
 				"fb_wlan_number_associations",
 				"Number of WLAN clients",
-				[]string{"ssid_standard"},
+				[]string{"udn", "ssid_standard", "guest"},
 				nil,
-			), prometheus.GaugeValue, totalAssociations, wlanNameStandard)
+			), prometheus.GaugeValue, totalAssociations, udn, wlanNameStandard, guest)
 
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-				"fb_wlan_total_packets_sent",
-				"WLAN total packets sent",
-				[]string{"ssid_standard"},
-				nil,
-			), prometheus.CounterValue, totalPacketsSent, wlanNameStandard)
+			if sentOK {
+				ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+					"fb_wlan_total_packets_sent",
+					"WLAN total packets sent",
+					[]string{"udn", "ssid_standard", "guest"},
+					nil,
+				), prometheus.CounterValue, totalPacketsSent, udn, wlanNameStandard, guest)
+			}
 
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-				"fb_wlan_total_packets_received",
-				"WLAN total packets received",
-				[]string{"ssid_standard"},
-				nil,
-			), prometheus.CounterValue, totalPacketsReceived, wlanNameStandard)
+			if receivedOK {
+				ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+					"fb_wlan_total_packets_received",
+					"WLAN total packets received",
+					[]string{"udn", "ssid_standard", "guest"},
+					nil,
+				), prometheus.CounterValue, totalPacketsReceived, udn, wlanNameStandard, guest)
+			}
 		}
 	}
-
 }
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_filterConvertAndCorrectByService(t *testing.T) {
-	sut := newFritzBoxCollector(&Config{})
+	sut := newFritzBoxCollector(&Config{}, nil)
 
-	val := sut.filterConvertAndCorrectByService([]serviceActionValue{
+	val, _ := sut.filterConvertAndCorrectByService([]serviceActionValue{
 		serviceActionValue{actionName: "action",
 			serviceType: "service",
 			value:       "4711",
@@ -18,7 +26,7 @@ func Test_filterConvertAndCorrectByService(t *testing.T) {
 
 	assert.Equal(t, float64(4711), val)
 
-	val = sut.filterConvertAndCorrectByService([]serviceActionValue{
+	val, _ = sut.filterConvertAndCorrectByService([]serviceActionValue{
 		serviceActionValue{actionName: "action",
 			serviceType: "service",
 			value:       "4712",
@@ -27,7 +35,7 @@ func Test_filterConvertAndCorrectByService(t *testing.T) {
 
 	assert.Equal(t, float64(4712), val)
 
-	val = sut.filterConvertAndCorrectByService([]serviceActionValue{
+	val, _ = sut.filterConvertAndCorrectByService([]serviceActionValue{
 		serviceActionValue{actionName: "action",
 			serviceType: "service",
 			value:       "100",
@@ -36,7 +44,7 @@ func Test_filterConvertAndCorrectByService(t *testing.T) {
 
 	assert.Equal(t, float64(0), val)
 
-	val = sut.filterConvertAndCorrectByService([]serviceActionValue{
+	val, _ = sut.filterConvertAndCorrectByService([]serviceActionValue{
 		serviceActionValue{actionName: "action",
 			serviceType: "service",
 			value:       "150",
@@ -45,7 +53,7 @@ func Test_filterConvertAndCorrectByService(t *testing.T) {
 
 	assert.Equal(t, float64(50), val)
 
-	val = sut.filterConvertAndCorrectByService([]serviceActionValue{
+	val, _ = sut.filterConvertAndCorrectByService([]serviceActionValue{
 		serviceActionValue{actionName: "action",
 			serviceType: "service",
 			value:       "120",
@@ -54,7 +62,7 @@ func Test_filterConvertAndCorrectByService(t *testing.T) {
 
 	assert.Equal(t, float64(0), val)
 
-	val = sut.filterConvertAndCorrectByService([]serviceActionValue{
+	val, _ = sut.filterConvertAndCorrectByService([]serviceActionValue{
 		serviceActionValue{actionName: "action",
 			serviceType: "service",
 			value:       "150",
@@ -64,3 +72,264 @@ func Test_filterConvertAndCorrectByService(t *testing.T) {
 	assert.Equal(t, float64(30), val)
 
 }
+
+func Test_filterConvertAndCorrectByServicePerDevice(t *testing.T) {
+	sut := newFritzBoxCollector(&Config{}, nil)
+	values := func(wan1, wan2 string) []serviceActionValue {
+		return []serviceActionValue{
+			{device: "uuid:wan1", serviceType: "WANCommonInterfaceConfig:1", actionName: "action", variable: "var", value: wan1},
+			{device: "uuid:wan2", serviceType: "WANCommonInterfaceConfig:1", actionName: "action", variable: "var", value: wan2},
+		}
+	}
+	corrected := func(in []serviceActionValue, udn string) float64 {
+		value, ok := sut.filterConvertAndCorrectByService(ofDevice(in, udn), "WANCommonInterfaceConfig", "action", "var")
+		assert.True(t, ok, udn)
+		return value
+	}
+
+	in := values("1000", "10")
+	assert.Equal(t, []string{"uuid:wan1", "uuid:wan2"}, devicesOf(in, "WANCommonInterfaceConfig"))
+	assert.Equal(t, float64(1000), corrected(in, "uuid:wan1"))
+	assert.Equal(t, float64(10), corrected(in, "uuid:wan2"))
+
+	// the smaller counter of the second device isn't taken as reset of the first one
+	in = values("1100", "20")
+	assert.Equal(t, float64(1100), corrected(in, "uuid:wan1"))
+	assert.Equal(t, float64(20), corrected(in, "uuid:wan2"))
+}
+
+func Test_filterConvertAndCorrectByServiceMissingValue(t *testing.T) {
+	sut := newFritzBoxCollector(&Config{}, nil)
+	value := func(v string) []serviceActionValue {
+		return []serviceActionValue{{serviceType: "service", actionName: "action", variable: "var", value: v}}
+	}
+
+	val, ok := sut.filterConvertAndCorrectByService(value("4711"), "service", "action", "var")
+	assert.Equal(t, float64(4711), val)
+	assert.True(t, ok)
+
+	// failed action or invalid value
+	_, ok = sut.filterConvertAndCorrectByService(nil, "service", "action", "var")
+	assert.False(t, ok)
+	_, ok = sut.filterConvertAndCorrectByService(value(""), "service", "action", "var")
+	assert.False(t, ok)
+
+	// no counter reset afterwards
+	val, ok = sut.filterConvertAndCorrectByService(value("4712"), "service", "action", "var")
+	assert.Equal(t, float64(4712), val)
+	assert.True(t, ok)
+}
+
+func Test_collectDefaultConnectionCountsChanges(t *testing.T) {
+	sut := newFritzBoxCollector(&Config{}, nil)
+
+	for _, connection := range []string{"1.WANPPPConnection.1", "1.WANPPPConnection.1", "2.X_AVM-DE_WANMobileConnection.1"} {
+		ch := make(chan prometheus.Metric, 2)
+		sut.collectDefaultConnection(ch, []serviceActionValue{
+			{serviceType: "urn:dslforum-org:service:Layer3Forwarding:1",
+				actionName: "GetDefaultConnectionService",
+				variable:   "DefaultConnectionService",
+				value:      connection},
+		})
+		close(ch)
+	}
+
+	assert.Equal(t, "2.X_AVM-DE_WANMobileConnection.1", sut.defaultConnection)
+	assert.Equal(t, float64(1), sut.defaultConnectionChanges)
+}
+
+func Test_collectUnreachableBox(t *testing.T) {
+	client, _ := NewUPnPClient(&Config{})
+	client.URL = "http://127.0.0.1:1"
+	sut := newFritzBoxCollector(&Config{}, client)
+
+	err := testutil.CollectAndCompare(sut, strings.NewReader(`
+# HELP fb_up Box is reachable and responds to TR-064 requests
+# TYPE fb_up gauge
+fb_up 0
+`), "fb_up")
+
+	assert.NoError(t, err)
+}
+
+func Test_collectSuspendsRequestsAfterFailedAuthentication(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		rw.Header().Set("WWW-Authenticate", `Digest realm="box", nonce="abc", qop="auth"`)
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client, _ := NewUPnPClient(&Config{User: "user", Password: "wrong"})
+	client.URL = server.URL
+	sut := newFritzBoxCollector(&Config{}, client)
+
+	expected := `
+# HELP fb_auth_failures_total Number of failed authentications
+# TYPE fb_auth_failures_total counter
+fb_auth_failures_total 1
+# HELP fb_auth_locked_out Requests to the box are suspended after failed authentication
+# TYPE fb_auth_locked_out gauge
+fb_auth_locked_out 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(sut, strings.NewReader(expected), "fb_auth_failures_total", "fb_auth_locked_out"))
+	assert.NoError(t, testutil.CollectAndCompare(sut, strings.NewReader(expected), "fb_auth_failures_total", "fb_auth_locked_out"))
+
+	// unauthenticated request and retry with credentials, no requests afterwards
+	assert.Equal(t, 2, requests)
+}
+
+func Test_CollectConcurrentScrapesShareOnePoll(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		time.Sleep(20 * time.Millisecond)
+		return map[string]string{"NewSoftwareVersion": "154.07.29", "NewUpTime": "3600", "NewHostNumberOfEntries": "3"}, 0
+	})
+	defer box.Close()
+	sut := newFritzBoxCollector(&Config{}, box.client())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				ch := make(chan prometheus.Metric, 1000)
+				sut.Collect(ch)
+				close(ch)
+				assert.NotEmpty(t, ch)
+
+				// correction state is updated by the polls
+				sut.filterConvertAndCorrectByService(nil, "service", "action", "var")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// each poll validates the service descriptions with one request
+	assert.True(t, box.requestCount("GetInfo") < 20, "requests: %d", box.requestCount("GetInfo"))
+}
+
+func Test_CollectServesLastPollInBackgroundMode(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		return map[string]string{"NewSoftwareVersion": "154.07.29", "NewUpTime": "3600", "NewHostNumberOfEntries": "3"}, 0
+	})
+	defer box.Close()
+	sut := newFritzBoxCollector(&Config{PollInterval: time.Hour}, box.client())
+
+	// only fb_last_successful_poll_timestamp_seconds before the first poll
+	assert.Equal(t, 1, testutil.CollectAndCount(sut))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sut.pollInBackground(ctx)
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCount(sut, "fb_up") == 1
+	}, time.Second, 10*time.Millisecond)
+
+	requests := box.requestCount("GetInfo")
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, 1, testutil.CollectAndCount(sut, "fb_up"))
+	}
+	assert.Equal(t, requests, box.requestCount("GetInfo"))
+}
+
+func Test_CollectDropsStaleValues(t *testing.T) {
+	upDesc := prometheus.NewDesc("fb_up", "Box is reachable and responds to TR-064 requests", nil, nil)
+	valueDesc := prometheus.NewDesc("fb_wanppp_status_uptime", "WAN PPP uptime", nil, nil)
+	expected := func(metrics string) *strings.Reader {
+		return strings.NewReader(`
+# HELP fb_up Box is reachable and responds to TR-064 requests
+# TYPE fb_up gauge
+fb_up 0
+` + metrics)
+	}
+	sut := newFritzBoxCollector(&Config{PollInterval: time.Minute, MaxStaleness: 5 * time.Minute}, nil)
+
+	sut.lastUp = &poll{
+		health:   []prometheus.Metric{prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)},
+		values:   []prometheus.Metric{prometheus.MustNewConstMetric(valueDesc, prometheus.CounterValue, 3600)},
+		up:       true,
+		finished: time.Now().Add(-4 * time.Minute),
+	}
+	sut.latest = &poll{
+		health:   []prometheus.Metric{prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0)},
+		finished: time.Now(),
+	}
+	// health of the failed poll, values of the last successful poll
+	assert.NoError(t, testutil.CollectAndCompare(sut, expected(`
+# HELP fb_wanppp_status_uptime WAN PPP uptime
+# TYPE fb_wanppp_status_uptime counter
+fb_wanppp_status_uptime 3600
+`), "fb_up", "fb_wanppp_status_uptime"))
+
+	sut.lastUp.finished = time.Now().Add(-6 * time.Minute)
+	assert.NoError(t, testutil.CollectAndCompare(sut, expected(""), "fb_up", "fb_wanppp_status_uptime"))
+
+	// no values at all if the last poll is stale, e.g. if polls hang
+	sut.latest = sut.lastUp
+	assert.Equal(t, 0, testutil.CollectAndCount(sut, "fb_up"))
+	assert.Equal(t, 0, testutil.CollectAndCount(sut, "fb_wanppp_status_uptime"))
+	assert.Equal(t, 1, testutil.CollectAndCount(sut, "fb_last_successful_poll_timestamp_seconds"))
+}
+
+func Test_CollectSharedPollOutlivesFirstScrape(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		time.Sleep(50 * time.Millisecond)
+		return map[string]string{"NewSoftwareVersion": "154.07.29", "NewUpTime": "3600", "NewHostNumberOfEntries": "3"}, 0
+	})
+	defer box.Close()
+	sut := newFritzBoxCollector(&Config{}, box.client())
+
+	second := make(chan []prometheus.Metric)
+	go func() {
+		// waits until the first scrape has started the poll
+		time.Sleep(5 * time.Millisecond)
+		second <- sut.snapshot(context.Background())
+	}()
+
+	// the first scrape gives up before the poll is done and reports the box as down
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assertUp(t, "0", sut.snapshot(ctx))
+
+	// the second scrape gets the values of the same poll
+	assertUp(t, "1", <-second)
+	assert.Equal(t, 1, box.requestCount(ENDPOINT))
+	assert.True(t, sut.latest.up)
+}
+
+func Test_CollectPollIsCancelledWithoutScrapes(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		time.Sleep(500 * time.Millisecond)
+		return map[string]string{"NewSoftwareVersion": "154.07.29", "NewUpTime": "3600"}, 0
+	})
+	defer box.Close()
+	sut := newFritzBoxCollector(&Config{PollTimeout: time.Minute}, box.client())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assertUp(t, "0", sut.snapshot(ctx))
+
+	sut.pollMu.Lock()
+	p := sut.inFlight
+	sut.pollMu.Unlock()
+	if p != nil {
+		<-p.done
+	}
+	assert.True(t, time.Since(start) < 400*time.Millisecond)
+}
+
+// assertUp checks the value of fb_up in passed metrics
+func assertUp(t *testing.T, up string, metrics []prometheus.Metric) {
+	assert.NoError(t, testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		for _, metric := range metrics {
+			ch <- metric
+		}
+	}), strings.NewReader(`
+# HELP fb_up Box is reachable and responds to TR-064 requests
+# TYPE fb_up gauge
+fb_up `+up+`
+`), "fb_up"))
+}
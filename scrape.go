@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeTimeoutMargin is subtracted from the scrape timeout of Prometheus, so there is time left to send the response
+const scrapeTimeoutMargin = 500 * time.Millisecond
+
+// scrapeContext returns the context of the scrape request, limited to the scrape timeout which Prometheus sends in the
+// header X-Prometheus-Scrape-Timeout-Seconds
+func scrapeContext(req *http.Request) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(req.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(req.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 2*scrapeTimeoutMargin {
+		timeout -= scrapeTimeoutMargin
+	}
+	return context.WithTimeout(req.Context(), timeout)
+}
+
+// scrapeCollector collects the metrics of the box with the context of a single scrape
+type scrapeCollector struct {
+	ctx       context.Context
+	collector *FritzBoxCollector
+}
+
+func (sc scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	sc.collector.Describe(ch)
+}
+
+func (sc scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range sc.collector.metrics(sc.ctx) {
+		ch <- metric
+	}
+}
+
+// handler serves the metrics of passed gatherer and of the box. Collect has no context, so the collector is
+// registered per scrape to pass the context of the scrape request down to the requests to the box.
+func (collector *FritzBoxCollector) handler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ctx, cancel := scrapeContext(req)
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(scrapeCollector{ctx: ctx, collector: collector})
+
+		promhttp.HandlerFor(prometheus.Gatherers{gatherer, registry}, promhttp.HandlerOpts{}).ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_scrapeContext(t *testing.T) {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "10")
+
+	ctx, cancel := scrapeContext(req)
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.InDelta(t, float64(9500*time.Millisecond), float64(time.Until(deadline)), float64(100*time.Millisecond))
+}
+
+func Test_scrapeContextWithoutTimeout(t *testing.T) {
+	ctx, cancel := scrapeContext(httptest.NewRequest("GET", "/metrics", nil))
+	defer cancel()
+
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var genericEntryAction = regexp.MustCompile(`^(X_AVM-DE_)?GetGeneric(.+)Entry$`)
+
+// maxTableEntries limits the number of entries of a table, a larger count is considered as invalid response
+const maxTableEntries = 10000
+
+// TableQuery selects a table of a service. Tables are modeled as an action returning the number of entries and an
+// action returning one entry by index.
+type TableQuery struct {
+	// ServiceType selects the first matching service (e.g. "Hosts" or "WLANConfiguration:2") of the device with the
+	// UDN, an empty UDN matches any device
+	ServiceType string
+	UDN         string
+	// EntryAction returns one entry by index (e.g. GetGenericHostEntry with NewIndex)
+	EntryAction string
+	// CountAction returns the number of entries (e.g. GetTotalAssociations for GetGenericAssociatedDeviceInfo). It is
+	// derived from the entry action if empty, e.g. GetHostNumberOfEntries for GetGenericHostEntry or
+	// GetNumberOfDectEntries for GetGenericDectEntry.
+	CountAction string
+	// WalkToEnd reads the entries until the box reports an invalid index (UPnP error 713) instead of reading the
+	// number of entries, for tables without count action (e.g. GetGenericDeviceInfos of X_AVM-DE_Homeauto)
+	WalkToEnd bool
+}
+
+// Table enumerates a table of the first service matching passed service type, the count action is derived from
+// passed entry action (see QueryTable)
+func (uc *UPnPClient) Table(ctx context.Context, serviceType string, entryAction string) ([]map[string]string, error) {
+	return uc.QueryTable(ctx, TableQuery{ServiceType: serviceType, EntryAction: entryAction})
+}
+
+// QueryTable enumerates the table selected by passed query. Returns the output arguments of all entries by name in
+// index order, entries vanishing during the walk (UPnP error 713 or 714) are skipped. The entries are fetched
+// concurrently with the configured number of concurrent requests.
+func (uc *UPnPClient) QueryTable(ctx context.Context, query TableQuery) ([]map[string]string, error) {
+	if err := uc.connect(ctx); err != nil {
+		return nil, err
+	}
+	catalog, err := uc.loadCatalog(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var service *Service
+	for i := range catalog.services {
+		s := &catalog.services[i]
+		if strings.Contains(s.ServiceType, query.ServiceType) && (len(query.UDN) == 0 || s.DeviceUDN == query.UDN) {
+			service = s
+			break
+		}
+	}
+	if service == nil {
+		return nil, &InvalidCallError{ServiceType: query.ServiceType, Action: query.EntryAction, Reason: "service not found"}
+	}
+
+	indexArgument, err := tableIndexArgument(*service, query.EntryAction)
+	if err != nil {
+		return nil, err
+	}
+	table := &tableWalk{uc: uc, service: *service, entryAction: query.EntryAction, indexArgument: indexArgument}
+
+	if query.WalkToEnd {
+		return table.readToEnd(ctx)
+	}
+
+	countAction, err := tableCountAction(*service, query.EntryAction, query.CountAction)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := uc.tableSize(ctx, *service, countAction)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, _, err := table.read(ctx, 0, entries)
+	if err != nil {
+		return nil, err
+	}
+	return compactRows(rows), nil
+}
+
+// tableSize returns the number of entries of a table read by passed count action
+func (uc *UPnPClient) tableSize(ctx context.Context, service Service, countAction Action) (int, error) {
+	count, err := uc.CallDevice(ctx, service.DeviceUDN, service.ServiceType, countAction.Name, nil)
+	if err != nil {
+		return 0, err
+	}
+	var entries int
+	for _, value := range count {
+		if entries, err = strconv.Atoi(value); err != nil {
+			return 0, &ParseError{URL: uc.url(service.ControlURL), Err: err}
+		}
+	}
+	if entries < 0 || entries > maxTableEntries {
+		return 0, &ParseError{
+			URL: uc.url(service.ControlURL),
+			Err: fmt.Errorf("number of entries %d out of range [0, %d]", entries, maxTableEntries),
+		}
+	}
+	return entries, nil
+}
+
+// tableWalk reads the entries of a table by index
+type tableWalk struct {
+	uc            *UPnPClient
+	service       Service
+	entryAction   string
+	indexArgument string
+}
+
+// read fetches n entries from passed index concurrently. Vanished entries are nil, end is the first index the box
+// reported as invalid (UPnP error 713) or -1. Fails with the first other error, no further requests are sent then.
+func (t *tableWalk) read(ctx context.Context, from int, n int) (rows []map[string]string, end int, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rows = make([]map[string]string, n)
+	end = -1
+	var mu sync.Mutex
+	t.uc.parallel(n, func(i int) {
+		if ctx.Err() != nil {
+			// no further requests after the first error
+			return
+		}
+		row, rowErr := t.uc.CallDevice(ctx, t.service.DeviceUDN, t.service.ServiceType, t.entryAction, map[string]string{
+			t.indexArgument: strconv.Itoa(from + i),
+		})
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case rowErr == nil:
+			rows[i] = row
+		case isInvalidIndex(rowErr):
+			if end < 0 || from+i < end {
+				end = from + i
+			}
+		case !isVanishedEntry(rowErr) && err == nil:
+			err = rowErr
+			cancel()
+		}
+	})
+
+	if err != nil {
+		return nil, end, err
+	}
+	return rows, end, nil
+}
+
+// readToEnd fetches the entries in batches of the configured number of concurrent requests until the box reports an
+// invalid index
+func (t *tableWalk) readToEnd(ctx context.Context) ([]map[string]string, error) {
+	var result []map[string]string
+	batch := t.uc.concurrency
+	if batch < 1 {
+		batch = 1
+	}
+	for from := 0; from < maxTableEntries; from += batch {
+		rows, end, err := t.read(ctx, from, batch)
+		if err != nil {
+			return nil, err
+		}
+		if end >= 0 {
+			return append(result, compactRows(rows[:end-from])...), nil
+		}
+		result = append(result, compactRows(rows)...)
+	}
+	return nil, &ParseError{
+		URL: t.uc.url(t.service.ControlURL),
+		Err: fmt.Errorf("no end of table %s after %d entries", t.entryAction, maxTableEntries),
+	}
+}
+
+// compactRows returns the rows without vanished entries
+func compactRows(rows []map[string]string) []map[string]string {
+	result := make([]map[string]string, 0, len(rows))
+	for _, row := range rows {
+		if row != nil {
+			result = append(result, row)
+		}
+	}
+	return result
+}
+
+// tableIndexArgument returns the name of the only input argument of passed entry action
+func tableIndexArgument(service Service, entryAction string) (string, error) {
+	var indexArgument string
+	for _, action := range service.Actions {
+		if action.Name != entryAction {
+			continue
+		}
+		for _, argument := range action.Arguments {
+			if argument.Direction == "in" {
+				if len(indexArgument) > 0 {
+					return "", &InvalidCallError{ServiceType: service.ServiceType, Action: entryAction, Reason: "more than one input argument"}
+				}
+				indexArgument = argument.Name
+			}
+		}
+	}
+	if len(indexArgument) == 0 {
+		return "", &InvalidCallError{ServiceType: service.ServiceType, Action: entryAction, Reason: "no index argument"}
+	}
+	return indexArgument, nil
+}
+
+// tableCountAction returns the count action with passed name or, if empty, the count action matching passed entry
+// action. A count action returns only the number of entries.
+func tableCountAction(service Service, entryAction string, countAction string) (Action, error) {
+	invalid := func(reason string) (Action, error) {
+		return Action{}, &InvalidCallError{ServiceType: service.ServiceType, Action: entryAction, Reason: reason}
+	}
+	isCount := func(action Action) bool {
+		return isGetAction(action.Name) && len(action.Arguments) == 1 && action.Arguments[0].Direction == "out"
+	}
+
+	if len(countAction) > 0 {
+		for _, action := range service.Actions {
+			if action.Name == countAction && isCount(action) {
+				return action, nil
+			}
+		}
+		return invalid(fmt.Sprintf("no count action %s", countAction))
+	}
+
+	match := genericEntryAction.FindStringSubmatch(entryAction)
+	if match == nil {
+		return invalid("no table entry action")
+	}
+	// e.g. GetHostNumberOfEntries for GetGenericHostEntry, GetNumberOfDectEntries for GetGenericDectEntry
+	for _, action := range service.Actions {
+		if isCount(action) && (strings.HasSuffix(action.Name, match[2]+"NumberOfEntries") ||
+			strings.HasSuffix(action.Name, "NumberOf"+match[2]+"Entries")) {
+			return action, nil
+		}
+	}
+	return invalid("no action with number of entries")
+}
+
+// isInvalidIndex returns true if the box reports the index as beyond the end of the table
+func isInvalidIndex(err error) bool {
+	var soapFaultError *SOAPFaultError
+	return errors.As(err, &soapFaultError) && soapFaultError.Code == upnpErrorSpecifiedArrayIndex
+}
+
+// isVanishedEntry returns true if the table entry was removed since the number of entries was read
+func isVanishedEntry(err error) bool {
+	var soapFaultError *SOAPFaultError
+	return errors.As(err, &soapFaultError) &&
+		(soapFaultError.Code == upnpErrorSpecifiedArrayIndex || soapFaultError.Code == upnpErrorNoSuchEntry)
+}
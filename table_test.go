@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TableSkipsVanishedEntries(t *testing.T) {
+	var inFlight, maxInFlight int32
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		switch action {
+		case "GetHostNumberOfEntries":
+			return map[string]string{"NewHostNumberOfEntries": "10"}, 0
+		case "GetGenericHostEntry":
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			if args["NewIndex"] == "3" {
+				return nil, upnpErrorNoSuchEntry
+			}
+			return map[string]string{"NewHostName": "host" + args["NewIndex"]}, 0
+		}
+		return nil, upnpErrorInvalidAction
+	})
+	defer box.Close()
+
+	sut := box.clientWithConcurrency(3)
+	rows, err := sut.Table(context.Background(), "Hosts", "GetGenericHostEntry")
+
+	assert.NoError(t, err)
+	assert.Len(t, rows, 9)
+	for i, row := range rows {
+		index := i
+		if i >= 3 {
+			index++
+		}
+		assert.Equal(t, "host"+strconv.Itoa(index), row["NewHostName"])
+	}
+	assert.True(t, maxInFlight <= 3)
+}
+
+func Test_TableFailsOnError(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		if action == "GetHostNumberOfEntries" {
+			return map[string]string{"NewHostNumberOfEntries": "100"}, 0
+		}
+		return nil, upnpErrorNotAuthorized
+	})
+	defer box.Close()
+
+	_, err := box.client().Table(context.Background(), "Hosts", "GetGenericHostEntry")
+
+	assert.Equal(t, "606", errorCode(err))
+	assert.True(t, box.requestCount("GetGenericHostEntry") < 100)
+}
+
+func Test_TableWithoutCountAction(t *testing.T) {
+	service := Service{Actions: []Action{{
+		Name:      "GetGenericHostEntry",
+		Arguments: []Argument{{Name: "NewIndex", Direction: "in"}},
+	}}}
+
+	_, err := tableCountAction(service, "GetGenericHostEntry", "")
+	assert.Equal(t, "invalid_call", errorKind(err))
+	_, err = tableCountAction(service, "GetGenericHostEntry", "GetGenericHostEntry")
+	assert.Equal(t, "invalid_call", errorKind(err))
+}
+
+func Test_tableCountAction(t *testing.T) {
+	count := func(name string) Action {
+		return Action{Name: name, Arguments: []Argument{{Name: "New" + name, Direction: "out"}}}
+	}
+	service := Service{Actions: []Action{
+		count("GetHostNumberOfEntries"),
+		count("GetNumberOfDectEntries"),
+		count("X_AVM-DE_GetNumberOfDeflections"),
+		count("GetTotalAssociations"),
+	}}
+
+	for _, test := range []struct {
+		entryAction string
+		countAction string
+		expected    string
+	}{
+		{"GetGenericHostEntry", "", "GetHostNumberOfEntries"},
+		{"GetGenericDectEntry", "", "GetNumberOfDectEntries"},
+		{"GetGenericAssociatedDeviceInfo", "GetTotalAssociations", "GetTotalAssociations"},
+	} {
+		action, err := tableCountAction(service, test.entryAction, test.countAction)
+
+		assert.NoError(t, err, test.entryAction)
+		assert.Equal(t, test.expected, action.Name)
+	}
+}
+
+func Test_QueryTableWithCountActionOfDevice(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		switch action {
+		case "GetTotalAssociations":
+			return map[string]string{"NewTotalAssociations": "2"}, 0
+		case "GetGenericAssociatedDeviceInfo":
+			return map[string]string{"NewAssociatedDeviceMACAddress": "AA:BB:CC:00:00:0" + args["NewAssociatedDeviceIndex"]}, 0
+		}
+		return nil, upnpErrorInvalidAction
+	})
+	defer box.Close()
+	sut := box.client()
+	query := TableQuery{
+		ServiceType: "WLANConfiguration:1",
+		UDN:         "uuid:739f2409-bccb-40e7-8e6d-3431C4A1B2C3",
+		EntryAction: "GetGenericAssociatedDeviceInfo",
+		CountAction: "GetTotalAssociations",
+	}
+
+	rows, err := sut.QueryTable(context.Background(), query)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"NewAssociatedDeviceMACAddress": "AA:BB:CC:00:00:00"},
+		{"NewAssociatedDeviceMACAddress": "AA:BB:CC:00:00:01"},
+	}, rows)
+
+	// no such service on the WAN device
+	query.UDN = "uuid:739f2409-bccb-40e7-8e6e-3431C4A1B2C3"
+	_, err = sut.QueryTable(context.Background(), query)
+	assert.Equal(t, "invalid_call", errorKind(err))
+}
+
+func Test_QueryTableWalkToEnd(t *testing.T) {
+	for _, entries := range []int{0, 1, 5, 6} {
+		box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+			index, _ := strconv.Atoi(args["NewIndex"])
+			switch {
+			case index >= entries:
+				return nil, upnpErrorSpecifiedArrayIndex
+			case index == 2:
+				return nil, upnpErrorNoSuchEntry
+			}
+			return map[string]string{"NewAIN": "ain" + args["NewIndex"]}, 0
+		})
+		sut := box.clientWithConcurrency(3)
+
+		rows, err := sut.QueryTable(context.Background(), TableQuery{
+			ServiceType: "X_AVM-DE_Homeauto",
+			EntryAction: "GetGenericDeviceInfos",
+			WalkToEnd:   true,
+		})
+
+		assert.NoError(t, err, entries)
+		var ains []string
+		for _, row := range rows {
+			ains = append(ains, row["NewAIN"])
+		}
+		var expected []string
+		for i := 0; i < entries; i++ {
+			if i != 2 {
+				expected = append(expected, "ain"+strconv.Itoa(i))
+			}
+		}
+		assert.Equal(t, expected, ains, entries)
+		// one batch after the last entry at most
+		assert.True(t, box.requestCount("GetGenericDeviceInfos") <= entries+3, "entries: %d", entries)
+		box.Close()
+	}
+}
+
+func Test_TableRejectsInvalidCount(t *testing.T) {
+	for _, count := range []string{"-1", "10001", "abc"} {
+		box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+			if action == "GetHostNumberOfEntries" {
+				return map[string]string{"NewHostNumberOfEntries": count}, 0
+			}
+			return map[string]string{"NewHostName": "host"}, 0
+		})
+
+		_, err := box.client().Table(context.Background(), "Hosts", "GetGenericHostEntry")
+
+		assert.Equal(t, "parse", errorKind(err), count)
+		assert.Equal(t, 0, box.requestCount("GetGenericHostEntry"), count)
+		box.Close()
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// newTLSClient creates a HTTP client which verifies the box certificate with the system CAs, a custom CA file or
+// the pinned certificate fingerprint
+func newTLSClient(cfg *Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newHTTPClient(cfg, tlsConfig), nil
+}
+
+func newTLSConfig(cfg *Config) (*tls.Config, error) {
+	if len(cfg.TLSFingerprint) > 0 || len(cfg.TLSPinFile) > 0 {
+		pinner, err := newCertificatePinner(cfg.TLSFingerprint, cfg.TLSPinFile)
+		if err != nil {
+			return nil, err
+		}
+		// the box certificate is self-signed, it is verified by its fingerprint only
+		return &tls.Config{
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: pinner.verify,
+		}, nil
+	}
+
+	if len(cfg.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		return &tls.Config{RootCAs: pool}, nil
+	}
+
+	// system CAs
+	return &tls.Config{}, nil
+}
+
+// certificatePinner accepts only the certificate with the pinned SHA256 fingerprint. If there is no fingerprint yet,
+// the first presented certificate is trusted and its fingerprint stored in the pin file (trust on first use).
+type certificatePinner struct {
+	mu          sync.Mutex
+	fingerprint string
+	file        string
+}
+
+func newCertificatePinner(fingerprint string, file string) (*certificatePinner, error) {
+	pinner := &certificatePinner{
+		fingerprint: normalizeFingerprint(fingerprint),
+		file:        file,
+	}
+	if len(pinner.fingerprint) == 0 && len(file) > 0 {
+		content, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("can't read pin file: %v", err)
+		}
+		pinner.fingerprint = normalizeFingerprint(string(content))
+	}
+	return pinner, nil
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+}
+
+func (p *certificatePinner) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("box presented no certificate")
+	}
+	sum := sha256.Sum256(rawCerts[0])
+	fingerprint := hex.EncodeToString(sum[:])
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.fingerprint) == 0 {
+		log.Warnf("trusting box certificate with fingerprint %s on first use", fingerprint)
+		if err := ioutil.WriteFile(p.file, []byte(fingerprint+"\n"), 0600); err != nil {
+			return fmt.Errorf("can't store certificate fingerprint: %v", err)
+		}
+		p.fingerprint = fingerprint
+		return nil
+	}
+
+	if fingerprint != p.fingerprint {
+		return fmt.Errorf("box certificate fingerprint %s doesn't match pinned fingerprint %s", fingerprint, p.fingerprint)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_certificatePinnerTrustOnFirstUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pin")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "fingerprint")
+
+	sut, err := newCertificatePinner("", file)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sut.verify([][]byte{[]byte("first")}, nil))
+
+	// fingerprint is restored from pin file
+	sut, err = newCertificatePinner("", file)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sut.verify([][]byte{[]byte("first")}, nil))
+	assert.Error(t, sut.verify([][]byte{[]byte("other")}, nil))
+}
+
+func Test_certificatePinnerFingerprint(t *testing.T) {
+	sum := sha256.Sum256([]byte("cert"))
+	fingerprint := hex.EncodeToString(sum[:])
+
+	sut, err := newCertificatePinner(fingerprint, "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, sut.verify([][]byte{[]byte("cert")}, nil))
+	assert.Error(t, sut.verify([][]byte{[]byte("other")}, nil))
+	assert.Error(t, sut.verify(nil, nil))
+}
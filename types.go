@@ -6,7 +6,12 @@ type Service struct {
 	ControlURL  string `xml:"controlURL"`
 	EventSubURL string `xml:"eventSubURL"`
 	SCPDURL     string `xml:"SCPDURL"`
-	Actions     []Action
+	// DeviceType and DeviceUDN of the device which provides the service
+	DeviceType string `xml:"-"`
+	DeviceUDN  string `xml:"-"`
+	// Actions and StateVariables are parsed from the SCPD
+	Actions        []Action
+	StateVariables []StateVariable
 }
 
 type Device struct {
@@ -33,3 +38,10 @@ type Argument struct {
 	Direction            string `xml:"direction"`
 	RelatedStateVariable string `xml:"relatedStateVariable"`
 }
+
+type StateVariable struct {
+	Name          string   `xml:"name"`
+	DataType      string   `xml:"dataType"`
+	DefaultValue  string   `xml:"defaultValue"`
+	AllowedValues []string `xml:"allowedValueList>allowedValue"`
+}
@@ -1,46 +1,160 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 const ENDPOINT string = "/tr64desc.xml"
 
 type UPnPClient struct {
-	URL      string
-	user     string
-	password string
-	// Services and actions (service is key, actions value) to fetch
-	servicesActions map[string][]string
+	host    string
+	auth    *digestAuth
+	guard   *authGuard
+	retries int
+	// maximum number of concurrent requests
+	concurrency int
+	// requests holds a slot for each request in flight, it limits the requests to the box to concurrency in all
+	// callers (polls, /all, mesh)
+	requests chan struct{}
+	// response time of actions by service and action
+	actionDuration *prometheus.HistogramVec
+	// tlsClient is only set if TLS is enabled, it's used after discovery of the HTTPS port
+	tlsClient *http.Client
+
+	connMu sync.Mutex
+	URL    string
+	client *http.Client
+
+	catalogRefresh time.Duration
+	catalogFile    string
+
+	mu sync.Mutex
+	// catalog of the service descriptions, nil until first use
+	catalog *catalog
 }
 
-func NewUPnPClient(cfg *Config, servicesActions map[string][]string) *UPnPClient {
-	return &UPnPClient{
-		URL:             fmt.Sprintf("http://%s:49000", cfg.URL),
-		user:            cfg.User,
-		password:        cfg.Password,
-		servicesActions: servicesActions,
+// NewUPnPClient creates a client for the TR-064 interface of the box. With enabled TLS, the HTTPS port is
+// discovered via DeviceInfo/GetSecurityPort on first use.
+func NewUPnPClient(cfg *Config) (*UPnPClient, error) {
+	uc := &UPnPClient{
+		host:        cfg.URL,
+		auth:        newDigestAuth(cfg.User, cfg.Password),
+		guard:       newAuthGuard(),
+		retries:     cfg.Retries,
+		concurrency: cfg.Concurrency,
+		actionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "fb_action_duration_seconds",
+			Help: "Response time of actions by service and action",
+		}, []string{"service", "action"}),
+		catalogRefresh: cfg.CatalogRefresh,
+		catalogFile:    cfg.CatalogFile,
+		URL:            fmt.Sprintf("http://%s:49000", cfg.URL),
+		client:         newHTTPClient(cfg, nil),
+	}
+	if uc.concurrency < 1 {
+		uc.concurrency = 1
+	}
+	uc.requests = make(chan struct{}, uc.concurrency)
+	if cfg.TLS {
+		client, err := newTLSClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		uc.tlsClient = client
 	}
+	return uc, nil
+}
+
+// connect discovers the HTTPS port if TLS is enabled and not done yet
+func (uc *UPnPClient) connect(ctx context.Context) error {
+	uc.connMu.Lock()
+	defer uc.connMu.Unlock()
+
+	if uc.tlsClient == nil || uc.client == uc.tlsClient {
+		return nil
+	}
+
+	content, err := uc.send(uc.client, newSOAPRequest(ctx, uc.URL, Service{
+		ServiceType: "urn:dslforum-org:service:DeviceInfo:1",
+		ControlURL:  "/upnp/control/deviceinfo",
+	}, Action{Name: "GetSecurityPort"}, nil))
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	port := decodeResponse(content)["NewSecurityPort"]
+	if len(port) == 0 {
+		return &ParseError{URL: uc.URL, Err: errors.New("can't discover TR-064 HTTPS port")}
+	}
+
+	log.Infof("using TR-064 HTTPS port %s", port)
+	uc.URL = fmt.Sprintf("https://%s:%s", uc.host, port)
+	uc.client = uc.tlsClient
+	return nil
+}
+
+// httpClient returns the HTTP client which is used after connect
+func (uc *UPnPClient) httpClient() *http.Client {
+	if uc.tlsClient != nil {
+		return uc.tlsClient
+	}
+	return uc.client
+}
+
+// url returns the URL of passed path on the box
+func (uc *UPnPClient) url(path string) string {
+	uc.connMu.Lock()
+	defer uc.connMu.Unlock()
+
+	return uc.URL + path
 }
 
 type serviceActionValue struct {
+	// UDN of the device which provides the service
+	device      string
 	serviceType string
 	actionName  string
 	variable    string
 	value       string
+	// data type and allowed values of the state variable (SCPD), empty if unknown
+	dataType      string
+	allowedValues []string
 }
 
-func (uc *UPnPClient) Execute() []serviceActionValue {
-	var result []serviceActionValue
-	for _, service := range uc.parseServices() {
-		serviceToFetch := len(uc.servicesActions) == 0
+// Execute fetches the values of passed services and actions (service is key, actions value), all if empty.
+// Returns ActionErrors if only some actions failed, the values of the other actions are valid in this case.
+func (uc *UPnPClient) Execute(ctx context.Context, servicesActions map[string][]string) ([]serviceActionValue, error) {
+	if err := uc.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	catalog, err := uc.loadCatalog(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	type job struct {
+		service Service
+		action  Action
+	}
+	var jobs []job
+	for _, service := range catalog.services {
+		serviceToFetch := len(servicesActions) == 0
 		var actionsToFetch []string
-		for k, actions := range uc.servicesActions {
+		for k, actions := range servicesActions {
 			if strings.Contains(service.ServiceType, k) {
 				actionsToFetch = actions
 				serviceToFetch = true
@@ -49,8 +163,11 @@ func (uc *UPnPClient) Execute() []serviceActionValue {
 		}
 
 		if serviceToFetch {
-			for _, action := range uc.parseActions(service) {
-				actionToFetch := len(uc.servicesActions) == 0
+			for _, action := range service.Actions {
+				if !IsActionGetOnly(action) {
+					continue
+				}
+				actionToFetch := len(servicesActions) == 0
 				for _, a := range actionsToFetch {
 					if a == action.Name {
 						actionToFetch = true
@@ -58,66 +175,286 @@ func (uc *UPnPClient) Execute() []serviceActionValue {
 					}
 				}
 				if actionToFetch {
+					jobs = append(jobs, job{service: service, action: action})
+				}
+			}
+		}
+	}
 
-					message := fmt.Sprintf(`
-		<?xml version="1.0"?> 
-        <s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" 
-				s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"> 
-            <s:Body><u:%s xmlns:u='%s'/></s:Body>
-		</s:Envelope>`, action.Name, service.ServiceType)
-
-					dr := newRequest("POST", uc.URL+service.ControlURL, message)
-
-					dr.Header.Add("Content-Type", "text/xml")
-					dr.Header.Add("charset", "utf-8")
-					dr.Header.Add("SoapAction", fmt.Sprintf("%s#%s", service.ServiceType, action.Name))
-
-					content := do(dr, uc.user, uc.password)
-					defer content.Close()
-					decoder := xml.NewDecoder(content)
-					for {
-						t, _ := decoder.Token()
-						if t == nil {
-							break
-						}
-						switch se := t.(type) {
-						case xml.StartElement:
-							for _, argument := range action.Arguments {
-								if se.Name.Local == argument.Name {
-									t, _ = decoder.Token()
-									switch element := t.(type) {
-									case xml.CharData:
-										result = append(result, serviceActionValue{
-											serviceType: service.ServiceType,
-											actionName:  action.Name,
-											variable:    argument.RelatedStateVariable,
-											value:       string(element),
-										})
-									}
-								}
-							}
-						}
+	values := make([][]serviceActionValue, len(jobs))
+	errs := make([]error, len(jobs))
+	uc.parallel(len(jobs), func(i int) {
+		values[i], errs[i] = uc.fetch(ctx, jobs[i].service, jobs[i].action)
+	})
+
+	// results in the order of the service descriptions, independent of the order of the responses
+	var result []serviceActionValue
+	var actionErrors ActionErrors
+	for i, job := range jobs {
+		if errs[i] != nil {
+			actionErrors = append(actionErrors, &ActionError{ServiceType: job.service.ServiceType, Action: job.action.Name, Err: errs[i]})
+			continue
+		}
+		result = append(result, values[i]...)
+	}
+	printResult(result)
+
+	if len(actionErrors) > 0 {
+		return result, actionErrors
+	}
+	return result, nil
+}
+
+// parallel calls f for all indices 0..n-1 with at most the configured number of concurrent calls
+func (uc *UPnPClient) parallel(n int, f func(i int)) {
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < uc.concurrency && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
+
+// fetch invokes the action without input arguments and returns the values of all output arguments
+func (uc *UPnPClient) fetch(ctx context.Context, service Service, action Action) ([]serviceActionValue, error) {
+	content, err := uc.invoke(ctx, service, action, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	var result []serviceActionValue
+	decoder := xml.NewDecoder(content)
+	for {
+		t, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ParseError{URL: uc.url(service.ControlURL), Err: err}
+		}
+		switch se := t.(type) {
+		case xml.StartElement:
+			for _, argument := range action.Arguments {
+				if se.Name.Local == argument.Name {
+					t, _ = decoder.Token()
+					switch element := t.(type) {
+					case xml.CharData:
+						stateVariable := service.stateVariable(argument.RelatedStateVariable)
+						result = append(result, serviceActionValue{
+							device:        service.DeviceUDN,
+							serviceType:   service.ServiceType,
+							actionName:    action.Name,
+							variable:      argument.RelatedStateVariable,
+							value:         string(element),
+							dataType:      stateVariable.DataType,
+							allowedValues: stateVariable.AllowedValues,
+						})
 					}
 				}
 			}
 		}
 	}
-	printResult(result)
-	return result
+	return result, nil
 }
 
-func printResult(m []serviceActionValue) {
-	for _, s := range m {
-		log.Debugf("%s:::%s/%s   =   %s\n", s.serviceType, s.actionName, s.variable, s.value)
+// newSOAPRequest builds the request of passed action, the input arguments are sent in the order of the SCPD with
+// escaped values
+func newSOAPRequest(ctx context.Context, baseURL string, service Service, action Action, args map[string]string) *http.Request {
+	var arguments strings.Builder
+	for _, argument := range action.Arguments {
+		value, ok := args[argument.Name]
+		if argument.Direction != "in" || !ok {
+			continue
+		}
+		fmt.Fprintf(&arguments, "<%s>", argument.Name)
+		xml.EscapeText(&arguments, []byte(value))
+		fmt.Fprintf(&arguments, "</%s>", argument.Name)
+	}
+	actionName := action.Name
+
+	message := fmt.Sprintf(`
+		<?xml version="1.0"?> 
+        <s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" 
+				s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"> 
+            <s:Body><u:%s xmlns:u='%s'>%s</u:%s></s:Body>
+		</s:Envelope>`, actionName, service.ServiceType, arguments.String(), actionName)
+
+	dr := newRequest(ctx, "POST", baseURL+service.ControlURL, message)
+
+	dr.Header.Add("Content-Type", "text/xml")
+	dr.Header.Add("charset", "utf-8")
+	dr.Header.Add("SoapAction", fmt.Sprintf("%s#%s", service.ServiceType, actionName))
+
+	return dr
+}
+
+// invoke calls the action of passed service with input arguments (argument name is key) and returns the SOAP response.
+// Get actions are retried on transient errors, because they don't change anything on the box.
+func (uc *UPnPClient) invoke(ctx context.Context, service Service, action Action, args map[string]string) (io.ReadCloser, error) {
+	start := time.Now()
+	defer func() {
+		uc.actionDuration.WithLabelValues(serviceName(service.ServiceType), action.Name).Observe(time.Since(start).Seconds())
+	}()
+
+	retries := 0
+	if isGetAction(action.Name) {
+		retries = uc.retries
 	}
+	return retry(ctx, retries, func() (io.ReadCloser, error) {
+		return uc.do(newSOAPRequest(ctx, uc.url(""), service, action, args))
+	})
+}
+
+// get fetches passed path (e.g. a service description) from the box, retried on transient errors
+func (uc *UPnPClient) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return retry(ctx, uc.retries, func() (io.ReadCloser, error) {
+		return uc.do(newRequest(ctx, "GET", uc.url(path), ""))
+	})
+}
+
+func (uc *UPnPClient) do(dr *http.Request) (io.ReadCloser, error) {
+	uc.connMu.Lock()
+	client := uc.client
+	uc.connMu.Unlock()
+
+	return uc.send(client, dr)
 }
 
-func (uc *UPnPClient) parseServices() []Service {
-	services := make([]Service, 0)
+// send does the request unless requests are suspended after failed authentication. It waits for a free request slot
+// of the box, which is held until the returned content is closed.
+func (uc *UPnPClient) send(client *http.Client, dr *http.Request) (io.ReadCloser, error) {
+	if err := uc.guard.check(dr.URL.String()); err != nil {
+		return nil, err
+	}
+
+	select {
+	case uc.requests <- struct{}{}:
+	case <-dr.Context().Done():
+		return nil, &NetworkError{URL: dr.URL.String(), Err: dr.Context().Err()}
+	}
+	release := func() { <-uc.requests }
 
-	dr := newRequest("GET", uc.URL+ENDPOINT, "")
+	content, err := do(client, uc.auth, dr)
 
-	decoder := xml.NewDecoder(do(dr, uc.user, uc.password))
+	var authError *AuthError
+	switch {
+	case errors.As(err, &authError):
+		uc.guard.failure()
+	case err == nil:
+		uc.guard.success()
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &releasingReadCloser{ReadCloser: content, release: release}, nil
+}
+
+// releasingReadCloser releases the request slot once the content is closed
+type releasingReadCloser struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releasingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// Call invokes the action of the first service matching passed service type (e.g. "Hosts" or "WLANConfiguration:2")
+// with input arguments by name and returns the output arguments by name. The arguments are validated against the
+// service description, all input arguments are required. Uses the cached service catalog.
+func (uc *UPnPClient) Call(ctx context.Context, serviceType string, actionName string, args map[string]string) (map[string]string, error) {
+	return uc.CallDevice(ctx, "", serviceType, actionName, args)
+}
+
+// CallDevice is like Call, but invokes the service of the device with passed UDN, which tells apart the services of
+// boxes with several WAN or LAN devices. An empty UDN matches any device.
+func (uc *UPnPClient) CallDevice(ctx context.Context, udn string, serviceType string, actionName string, args map[string]string) (map[string]string, error) {
+	if err := uc.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	catalog, err := uc.loadCatalog(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range catalog.services {
+		if !strings.Contains(service.ServiceType, serviceType) || (len(udn) > 0 && service.DeviceUDN != udn) {
+			continue
+		}
+		action, err := findAction(service, actionName, args)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := uc.invoke(ctx, service, action, args)
+		if err != nil {
+			return nil, &ActionError{ServiceType: service.ServiceType, Action: actionName, Err: err}
+		}
+		defer content.Close()
+
+		values := decodeResponse(content)
+		result := make(map[string]string)
+		for _, argument := range action.Arguments {
+			if value, ok := values[argument.Name]; ok && argument.Direction == "out" {
+				result[argument.Name] = value
+			}
+		}
+		return result, nil
+	}
+	return nil, &InvalidCallError{ServiceType: serviceType, Action: actionName, Reason: "service not found"}
+}
+
+// findAction returns the action of the service description and validates passed input arguments
+func findAction(service Service, actionName string, args map[string]string) (Action, error) {
+	invalid := func(reason string, a ...interface{}) (Action, error) {
+		return Action{}, &InvalidCallError{ServiceType: service.ServiceType, Action: actionName, Reason: fmt.Sprintf(reason, a...)}
+	}
+
+	for _, action := range service.Actions {
+		if action.Name != actionName {
+			continue
+		}
+		in := make(map[string]bool)
+		for _, argument := range action.Arguments {
+			if argument.Direction != "in" {
+				continue
+			}
+			in[argument.Name] = true
+			if _, ok := args[argument.Name]; !ok {
+				return invalid("missing argument %s", argument.Name)
+			}
+		}
+		for name := range args {
+			if !in[name] {
+				return invalid("unknown argument %s", name)
+			}
+		}
+		return action, nil
+	}
+	return invalid("action not found")
+}
+
+// decodeResponse returns the text of all elements in the SOAP response, element name is key
+func decodeResponse(r io.Reader) map[string]string {
+	result := make(map[string]string)
+	decoder := xml.NewDecoder(r)
+	var name string
 	for {
 		t, _ := decoder.Token()
 		if t == nil {
@@ -125,52 +462,115 @@ func (uc *UPnPClient) parseServices() []Service {
 		}
 		switch se := t.(type) {
 		case xml.StartElement:
-			if se.Name.Local == "service" {
-				var service Service
-				if err := decoder.DecodeElement(&service, &se); err != nil {
-					panic(err)
-				}
+			name = se.Name.Local
+		case xml.CharData:
+			if len(name) > 0 {
+				result[name] = string(se)
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+	return result
+}
+
+func printResult(m []serviceActionValue) {
+	for _, s := range m {
+		log.Debugf("%s:::%s/%s   =   %s\n", s.serviceType, s.actionName, s.variable, s.value)
+	}
+}
 
-				//if strings.Contains(service.ServiceId, "WLANConfiguration") {
-				service.Actions = uc.parseActions(service)
-				services = append(services, service)
-				//}
+// parseServices parses the device tree of the box and the descriptions of all its services
+func (uc *UPnPClient) parseServices(ctx context.Context) (Device, bool, error) {
+	content, err := uc.get(ctx, ENDPOINT)
+	if err != nil {
+		return Device{}, false, err
+	}
 
+	var description struct {
+		Device Device `xml:"device"`
+	}
+	err = xml.NewDecoder(content).Decode(&description)
+	// frees the request slot for the service descriptions
+	content.Close()
+	if err != nil {
+		return Device{}, false, &ParseError{URL: uc.url(ENDPOINT), Err: err}
+	}
+
+	incomplete := false
+
+	var walk func(device *Device)
+	walk = func(device *Device) {
+		for i := range device.Services {
+			service := &device.Services[i]
+			service.DeviceType = device.DeviceType
+			service.DeviceUDN = device.UDN
+
+			var err error
+			service.Actions, service.StateVariables, err = uc.parseSCPD(ctx, *service)
+			if err != nil {
+				log.Warnf("can't parse description of service %s: %v", service.ServiceType, err)
+				incomplete = true
 			}
 		}
+		for i := range device.Devices {
+			walk(&device.Devices[i])
+		}
 	}
-	return services
+	walk(&description.Device)
+
+	return description.Device, incomplete, nil
 }
 
-func (uc *UPnPClient) parseActions(service Service) []Action {
+// parseSCPD returns the actions and state variables of the service description
+func (uc *UPnPClient) parseSCPD(ctx context.Context, service Service) ([]Action, []StateVariable, error) {
 	actions := make([]Action, 0)
+	var variables []StateVariable
+
+	content, err := uc.get(ctx, service.SCPDURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer content.Close()
 
-	dr := newRequest("GET", uc.URL+service.SCPDURL, "")
-	decoder := xml.NewDecoder(do(dr, uc.user, uc.password))
+	decoder := xml.NewDecoder(content)
 	for {
-		t, _ := decoder.Token()
-		if t == nil {
+		t, err := decoder.Token()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, nil, &ParseError{URL: uc.url(service.SCPDURL), Err: err}
+		}
 		switch se := t.(type) {
 		case xml.StartElement:
 			if se.Name.Local == "action" {
 				var action Action
 				if err := decoder.DecodeElement(&action, &se); err != nil {
-					panic(err)
+					return nil, nil, &ParseError{URL: uc.url(service.SCPDURL), Err: err}
 				}
-				if IsActionGetOnly(action) {
-					actions = append(actions, action)
+				actions = append(actions, action)
+			}
+			if se.Name.Local == "stateVariable" {
+				var variable StateVariable
+				if err := decoder.DecodeElement(&variable, &se); err != nil {
+					return nil, nil, &ParseError{URL: uc.url(service.SCPDURL), Err: err}
 				}
+				variables = append(variables, variable)
 			}
 		}
 	}
-	return actions
+	return actions, variables, nil
+}
+
+// isGetAction returns true for actions which only read values, e.g. GetInfo or X_AVM-DE_GetHostListPath
+func isGetAction(actionName string) bool {
+	match, _ := regexp.MatchString("^(.*Get)+[A-z]*", actionName)
+	return match
 }
 
 func IsActionGetOnly(action Action) bool {
-	match, _ := regexp.MatchString("^(.*Get)+[A-z]*", action.Name)
-	if !match {
+	if !isGetAction(action.Name) {
 		return false
 	}
 	for _, a := range action.Arguments {
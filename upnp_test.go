@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTR64Desc = `<?xml version="1.0"?>
+<root xmlns="urn:dslforum-org:device-1-0">
+<device>
+<deviceType>urn:dslforum-org:device:InternetGatewayDevice:1</deviceType>
+<friendlyName>FRITZ!Box 7590</friendlyName>
+<UDN>uuid:739f2409-bccb-40e7-8e6c-3431C4A1B2C3</UDN>
+<serviceList>
+<service>
+<serviceType>urn:dslforum-org:service:DeviceInfo:1</serviceType>
+<serviceId>urn:DeviceInfo-com:serviceId:DeviceInfo1</serviceId>
+<controlURL>/upnp/control/deviceinfo</controlURL>
+<eventSubURL>/upnp/control/deviceinfo</eventSubURL>
+<SCPDURL>/deviceinfoSCPD.xml</SCPDURL>
+</service>
+<service>
+<serviceType>urn:dslforum-org:service:X_AVM-DE_Homeauto:1</serviceType>
+<serviceId>urn:X_AVM-DE_Homeauto-com:serviceId:X_AVM-DE_Homeauto1</serviceId>
+<controlURL>/upnp/control/x_homeauto</controlURL>
+<eventSubURL>/upnp/control/x_homeauto</eventSubURL>
+<SCPDURL>/x_homeautoSCPD.xml</SCPDURL>
+</service>
+</serviceList>
+<deviceList>
+<device>
+<deviceType>urn:dslforum-org:device:LANDevice:1</deviceType>
+<friendlyName>FRITZ!Box 7590</friendlyName>
+<UDN>uuid:739f2409-bccb-40e7-8e6d-3431C4A1B2C3</UDN>
+<serviceList>
+<service>
+<serviceType>urn:dslforum-org:service:Hosts:1</serviceType>
+<serviceId>urn:LanDeviceHosts-com:serviceId:Hosts1</serviceId>
+<controlURL>/upnp/control/hosts</controlURL>
+<eventSubURL>/upnp/control/hosts</eventSubURL>
+<SCPDURL>/hostsSCPD.xml</SCPDURL>
+</service>
+<service>
+<serviceType>urn:dslforum-org:service:X_AVM-DE_HostFilter:1</serviceType>
+<serviceId>urn:X_AVM-DE_HostFilter-com:serviceId:X_AVM-DE_HostFilter1</serviceId>
+<controlURL>/upnp/control/x_hostfilter</controlURL>
+<eventSubURL>/upnp/control/x_hostfilter</eventSubURL>
+<SCPDURL>/x_hostfilterSCPD.xml</SCPDURL>
+</service>
+<service>
+<serviceType>urn:dslforum-org:service:WLANConfiguration:1</serviceType>
+<serviceId>urn:WLANConfiguration-com:serviceId:WLANConfiguration1</serviceId>
+<controlURL>/upnp/control/wlanconfig1</controlURL>
+<eventSubURL>/upnp/control/wlanconfig1</eventSubURL>
+<SCPDURL>/wlanconfigSCPD.xml</SCPDURL>
+</service>
+</serviceList>
+</device>
+<device>
+<deviceType>urn:dslforum-org:device:WANDevice:1</deviceType>
+<friendlyName>FRITZ!Box 7590</friendlyName>
+<UDN>uuid:739f2409-bccb-40e7-8e6e-3431C4A1B2C3</UDN>
+<deviceList>
+<device>
+<deviceType>urn:dslforum-org:device:WANConnectionDevice:1</deviceType>
+<friendlyName>FRITZ!Box 7590</friendlyName>
+<UDN>uuid:739f2409-bccb-40e7-8e6f-3431C4A1B2C3</UDN>
+</device>
+</deviceList>
+</device>
+</deviceList>
+</device>
+</root>`
+
+const testDeviceInfoSCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:dslforum-org:service-1-0">
+<actionList>
+<action>
+<name>GetInfo</name>
+<argumentList>
+<argument><name>NewModelName</name><direction>out</direction><relatedStateVariable>ModelName</relatedStateVariable></argument>
+<argument><name>NewSoftwareVersion</name><direction>out</direction><relatedStateVariable>SoftwareVersion</relatedStateVariable></argument>
+<argument><name>NewUpTime</name><direction>out</direction><relatedStateVariable>UpTime</relatedStateVariable></argument>
+</argumentList>
+</action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>ModelName</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SoftwareVersion</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>UpTime</name><dataType>ui4</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
+
+const testHostsSCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:dslforum-org:service-1-0">
+<actionList>
+<action>
+<name>GetHostNumberOfEntries</name>
+<argumentList>
+<argument><name>NewHostNumberOfEntries</name><direction>out</direction><relatedStateVariable>HostNumberOfEntries</relatedStateVariable></argument>
+</argumentList>
+</action>
+<action>
+<name>GetGenericHostEntry</name>
+<argumentList>
+<argument><name>NewIndex</name><direction>in</direction><relatedStateVariable>HostNumberOfEntries</relatedStateVariable></argument>
+<argument><name>NewIPAddress</name><direction>out</direction><relatedStateVariable>IPAddress</relatedStateVariable></argument>
+<argument><name>NewMACAddress</name><direction>out</direction><relatedStateVariable>MACAddress</relatedStateVariable></argument>
+<argument><name>NewActive</name><direction>out</direction><relatedStateVariable>Active</relatedStateVariable></argument>
+<argument><name>NewHostName</name><direction>out</direction><relatedStateVariable>HostName</relatedStateVariable></argument>
+</argumentList>
+</action>
+<action>
+<name>X_AVM-DE_SetHostNameByMACAddress</name>
+<argumentList>
+<argument><name>NewMACAddress</name><direction>in</direction><relatedStateVariable>MACAddress</relatedStateVariable></argument>
+<argument><name>NewHostName</name><direction>in</direction><relatedStateVariable>HostName</relatedStateVariable></argument>
+</argumentList>
+</action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>HostNumberOfEntries</name><dataType>ui2</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>IPAddress</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>MACAddress</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>Active</name><dataType>boolean</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>HostName</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
+
+const testHostFilterSCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:dslforum-org:service-1-0">
+<actionList>
+<action>
+<name>GetWANAccessByIP</name>
+<argumentList>
+<argument><name>NewIPv4Address</name><direction>in</direction><relatedStateVariable>IPv4Address</relatedStateVariable></argument>
+<argument><name>NewDisallow</name><direction>out</direction><relatedStateVariable>Disallow</relatedStateVariable></argument>
+<argument><name>NewWANAccess</name><direction>out</direction><relatedStateVariable>WANAccess</relatedStateVariable></argument>
+</argumentList>
+</action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>IPv4Address</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>Disallow</name><dataType>boolean</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>WANAccess</name><dataType>string</dataType><allowedValueList><allowedValue>granted</allowedValue><allowedValue>denied</allowedValue><allowedValue>error</allowedValue></allowedValueList></stateVariable>
+</serviceStateTable>
+</scpd>`
+
+const testWLANConfigurationSCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:dslforum-org:service-1-0">
+<actionList>
+<action>
+<name>GetTotalAssociations</name>
+<argumentList>
+<argument><name>NewTotalAssociations</name><direction>out</direction><relatedStateVariable>TotalAssociations</relatedStateVariable></argument>
+</argumentList>
+</action>
+<action>
+<name>GetGenericAssociatedDeviceInfo</name>
+<argumentList>
+<argument><name>NewAssociatedDeviceIndex</name><direction>in</direction><relatedStateVariable>TotalAssociations</relatedStateVariable></argument>
+<argument><name>NewAssociatedDeviceMACAddress</name><direction>out</direction><relatedStateVariable>AssociatedDeviceMACAddress</relatedStateVariable></argument>
+</argumentList>
+</action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>TotalAssociations</name><dataType>ui2</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>AssociatedDeviceMACAddress</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
+
+const testHomeautoSCPD = `<?xml version="1.0"?>
+<scpd xmlns="urn:dslforum-org:service-1-0">
+<actionList>
+<action>
+<name>GetGenericDeviceInfos</name>
+<argumentList>
+<argument><name>NewIndex</name><direction>in</direction><relatedStateVariable>Index</relatedStateVariable></argument>
+<argument><name>NewAIN</name><direction>out</direction><relatedStateVariable>AIN</relatedStateVariable></argument>
+</argumentList>
+</action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>Index</name><dataType>ui2</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>AIN</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
+
+// testBox is a fake box which serves the service descriptions above, actions are answered by the handler with the
+// output arguments or a UPnP error code
+type testBox struct {
+	*httptest.Server
+	handler func(action string, args map[string]string) (map[string]string, int)
+
+	mu sync.Mutex
+	// requests by path (descriptions) or action
+	requests map[string]int
+	// number of requests of a description path which fail with status 500
+	failures map[string]int
+}
+
+func newTestBox(handler func(action string, args map[string]string) (map[string]string, int)) *testBox {
+	box := &testBox{
+		handler:  handler,
+		requests: make(map[string]int),
+		failures: make(map[string]int),
+	}
+	descriptions := map[string]string{
+		ENDPOINT:                testTR64Desc,
+		"/deviceinfoSCPD.xml":   testDeviceInfoSCPD,
+		"/hostsSCPD.xml":        testHostsSCPD,
+		"/x_hostfilterSCPD.xml": testHostFilterSCPD,
+		"/wlanconfigSCPD.xml":   testWLANConfigurationSCPD,
+		"/x_homeautoSCPD.xml":   testHomeautoSCPD,
+	}
+	box.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if description, ok := descriptions[req.URL.Path]; ok {
+			if box.count(req.URL.Path) <= box.failures[req.URL.Path] {
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			rw.Write([]byte(description))
+			return
+		}
+
+		action := req.Header.Get("SoapAction")
+		action = action[strings.Index(action, "#")+1:]
+		box.count(action)
+
+		args := decodeResponse(req.Body)
+		values, code := box.handler(action, args)
+		if code > 0 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(rw, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>
+<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>
+<detail><UPnPError><errorCode>%d</errorCode></UPnPError></detail></s:Fault></s:Body></s:Envelope>`, code)
+			return
+		}
+
+		var response strings.Builder
+		for name, value := range values {
+			fmt.Fprintf(&response, "<%s>%s</%s>", name, value, name)
+		}
+		fmt.Fprintf(rw, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:%sResponse>%s</u:%sResponse></s:Body></s:Envelope>`,
+			action, response.String(), action)
+	}))
+	return box
+}
+
+// count counts a request and returns the number of requests of key so far
+func (box *testBox) count(key string) int {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+
+	box.requests[key]++
+	return box.requests[key]
+}
+
+func (box *testBox) requestCount(key string) int {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+
+	return box.requests[key]
+}
+
+func (box *testBox) client() *UPnPClient {
+	return box.clientWithConcurrency(1)
+}
+
+func (box *testBox) clientWithConcurrency(concurrency int) *UPnPClient {
+	client, _ := NewUPnPClient(&Config{Concurrency: concurrency})
+	client.URL = box.URL
+	return client
+}
+
+func Test_CallWithEscapedArguments(t *testing.T) {
+	var received map[string]string
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		received = args
+		return map[string]string{"Unexpected": "value"}, 0
+	})
+	defer box.Close()
+
+	result, err := box.client().Call(context.Background(), "Hosts", "X_AVM-DE_SetHostNameByMACAddress", map[string]string{
+		"NewMACAddress": "00:11:22:33:44:55",
+		"NewHostName":   "Tom & Jerry <tv>",
+	})
+
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+	assert.Equal(t, "Tom & Jerry <tv>", received["NewHostName"])
+	assert.Equal(t, "00:11:22:33:44:55", received["NewMACAddress"])
+}
+
+func Test_CallReturnsOutputArguments(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		if args["NewIndex"] != "1" {
+			return nil, upnpErrorSpecifiedArrayIndex
+		}
+		return map[string]string{"NewIPAddress": "192.168.178.20", "NewActive": "1"}, 0
+	})
+	defer box.Close()
+	sut := box.client()
+
+	result, err := sut.Call(context.Background(), "Hosts", "GetGenericHostEntry", map[string]string{"NewIndex": "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"NewIPAddress": "192.168.178.20", "NewActive": "1"}, result)
+
+	_, err = sut.Call(context.Background(), "Hosts", "GetGenericHostEntry", map[string]string{"NewIndex": "2"})
+	assert.Equal(t, "713", errorCode(err))
+}
+
+func Test_CallValidatesArguments(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		return nil, 0
+	})
+	defer box.Close()
+	sut := box.client()
+
+	for name, args := range map[string]map[string]string{
+		"missing": {},
+		"unknown": {"NewIndex": "1", "NewSyncGroupIndex": "0"},
+	} {
+		_, err := sut.Call(context.Background(), "Hosts", "GetGenericHostEntry", args)
+		assert.Equal(t, "invalid_call", errorKind(err), name)
+	}
+
+	_, err := sut.Call(context.Background(), "Hosts", "Reboot", nil)
+	assert.Equal(t, "invalid_call", errorKind(err))
+	_, err = sut.Call(context.Background(), "WANIPConnection", "GetInfo", nil)
+	assert.Equal(t, "invalid_call", errorKind(err))
+
+	assert.Equal(t, 0, box.requestCount("GetGenericHostEntry"))
+}
+
+func Test_CallDevice(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		return map[string]string{"NewHostNumberOfEntries": "3"}, 0
+	})
+	defer box.Close()
+	sut := box.client()
+
+	result, err := sut.CallDevice(context.Background(), "uuid:739f2409-bccb-40e7-8e6d-3431C4A1B2C3", "Hosts", "GetHostNumberOfEntries", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"NewHostNumberOfEntries": "3"}, result)
+
+	_, err = sut.CallDevice(context.Background(), "uuid:739f2409-bccb-40e7-8e6f-3431C4A1B2C3", "Hosts", "GetHostNumberOfEntries", nil)
+	assert.Equal(t, "invalid_call", errorKind(err))
+	assert.Equal(t, 1, box.requestCount("GetHostNumberOfEntries"))
+}
+
+func Test_Devices(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		return map[string]string{"NewHostNumberOfEntries": "3"}, 0
+	})
+	defer box.Close()
+	sut := box.client()
+
+	values, err := sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+	assert.NoError(t, err)
+	assert.Len(t, values, 1)
+	assert.Equal(t, "uuid:739f2409-bccb-40e7-8e6d-3431C4A1B2C3", values[0].device)
+
+	devices, err := sut.Devices(context.Background())
+	assert.NoError(t, err)
+	var types []string
+	for _, device := range devices {
+		types = append(types, deviceTypeName(device.DeviceType))
+	}
+	assert.Equal(t, []string{"InternetGatewayDevice:1", "LANDevice:1", "WANDevice:1", "WANConnectionDevice:1"}, types)
+	assert.Equal(t, "urn:dslforum-org:device:LANDevice:1", devices[1].Services[0].DeviceType)
+	assert.Len(t, devices[1].Services[0].Actions, 3)
+}
+
+func Test_ExecuteConcurrentlyInDescriptionOrder(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		if action == "GetInfo" {
+			// responds after GetHostNumberOfEntries
+			time.Sleep(50 * time.Millisecond)
+		}
+		return map[string]string{"NewModelName": "FRITZ!Box 7590", "NewHostNumberOfEntries": "3"}, 0
+	})
+	defer box.Close()
+	sut := box.clientWithConcurrency(2)
+
+	values, err := sut.Execute(context.Background(), map[string][]string{
+		"DeviceInfo": {"GetInfo"},
+		"Hosts":      {"GetHostNumberOfEntries"},
+	})
+
+	assert.NoError(t, err)
+	var variables []string
+	for _, v := range values {
+		variables = append(variables, v.variable)
+	}
+	assert.Equal(t, []string{"ModelName", "HostNumberOfEntries"}, variables)
+}
+
+func Test_ConcurrencyIsLimitedForAllCallers(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if action == "GetGenericHostEntry" && args["NewIndex"] == "5" {
+			return nil, upnpErrorSpecifiedArrayIndex
+		}
+		return map[string]string{"NewHostNumberOfEntries": "5", "NewHostName": "host"}, 0
+	})
+	defer box.Close()
+	sut := box.clientWithConcurrency(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := sut.Table(context.Background(), "Hosts", "GetGenericHostEntry")
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := sut.Execute(context.Background(), map[string][]string{"Hosts": {"GetHostNumberOfEntries"}})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, maxInFlight)
+}
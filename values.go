@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dateTime formats of the box, with and without time zone
+var dateTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// stateVariable returns the state variable of the service description or an empty one if it is unknown
+func (s Service) stateVariable(name string) StateVariable {
+	for _, variable := range s.StateVariables {
+		if variable.Name == name {
+			return variable
+		}
+	}
+	return StateVariable{Name: name}
+}
+
+// float converts the value according to the data type of its state variable: booleans to 0 or 1, dateTimes to Unix
+// timestamps, all other types are parsed as number. Returns false if the value can't be converted.
+func (v serviceActionValue) float() (float64, bool) {
+	value := strings.TrimSpace(v.value)
+	switch v.dataType {
+	case "boolean":
+		switch strings.ToLower(value) {
+		case "1", "true", "yes":
+			return 1, true
+		case "0", "false", "no":
+			return 0, true
+		}
+		return 0, false
+	case "dateTime", "dateTime.tz", "date":
+		t, err := parseDateTime(value)
+		if err != nil || t.IsZero() {
+			return 0, false
+		}
+		return float64(t.Unix()), true
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return f, err == nil
+}
+
+// parseDateTime parses a dateTime of the box, the local time zone is used if the value has none
+func parseDateTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range dateTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// collectStateSets exports all values with a list of allowed values as state set, the series of the current state
+// is 1, all others 0
+func (collector *FritzBoxCollector) collectStateSets(ch chan<- prometheus.Metric, values []serviceActionValue) {
+	state := prometheus.NewDesc(
+		"fb_state",
+		"Current state of state variables with allowed values (1 for the current state)",
+		[]string{"udn", "service", "variable", "state"},
+		nil,
+	)
+
+	seen := make(map[string]bool)
+	for _, v := range values {
+		key := fmt.Sprintf("%s/%s/%s", v.device, v.serviceType, v.variable)
+		if len(v.allowedValues) == 0 || v.dataType != "string" || seen[key] {
+			continue
+		}
+		// the same variable may be returned by several actions of the service
+		seen[key] = true
+
+		known := false
+		for _, allowed := range v.allowedValues {
+			known = known || allowed == v.value
+			ch <- prometheus.MustNewConstMetric(state, prometheus.GaugeValue, boolToFloat(allowed == v.value),
+				v.device, serviceName(v.serviceType), v.variable, allowed)
+		}
+		if !known && len(v.value) > 0 {
+			ch <- prometheus.MustNewConstMetric(state, prometheus.GaugeValue, 1, v.device, serviceName(v.serviceType), v.variable, v.value)
+		}
+	}
+}
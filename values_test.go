@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// collectorFunc adapts a collect function of the FritzBoxCollector for testutil
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(ch chan<- *prometheus.Desc) {}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) { f(ch) }
+
+func Test_serviceActionValueFloat(t *testing.T) {
+	timestamp := time.Date(2021, 3, 4, 10, 11, 12, 0, time.UTC)
+	for _, test := range []struct {
+		dataType string
+		value    string
+		expected float64
+		ok       bool
+	}{
+		{"ui4", "4294967295", 4294967295, true},
+		{"i4", "-12", -12, true},
+		{"boolean", "1", 1, true},
+		{"boolean", "false", 0, true},
+		{"boolean", "maybe", 0, false},
+		{"dateTime", "2021-03-04T10:11:12Z", float64(timestamp.Unix()), true},
+		{"dateTime", "0001-01-01T00:00:00", 0, false},
+		{"string", "Connected", 0, false},
+		{"", "42", 42, true},
+	} {
+		f, ok := serviceActionValue{dataType: test.dataType, value: test.value}.float()
+
+		assert.Equal(t, test.expected, f, test.value)
+		assert.Equal(t, test.ok, ok, test.value)
+	}
+}
+
+func Test_collectStateSets(t *testing.T) {
+	sut := newFritzBoxCollector(&Config{}, nil)
+	status := serviceActionValue{
+		device:        "uuid:75802409-bccb-40e7-8e6c-3431C4A1B2C3",
+		serviceType:   "urn:dslforum-org:service:WANPPPConnection:1",
+		actionName:    "GetInfo",
+		variable:      "ConnectionStatus",
+		value:         "Connected",
+		dataType:      "string",
+		allowedValues: []string{"Unconfigured", "Connected", "Disconnected"},
+	}
+	statusInfo := status
+	statusInfo.actionName = "GetStatusInfo"
+
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		sut.collectStateSets(ch, []serviceActionValue{status, statusInfo})
+	}), strings.NewReader(`
+# HELP fb_state Current state of state variables with allowed values (1 for the current state)
+# TYPE fb_state gauge
+fb_state{service="WANPPPConnection:1",state="Connected",udn="uuid:75802409-bccb-40e7-8e6c-3431C4A1B2C3",variable="ConnectionStatus"} 1
+fb_state{service="WANPPPConnection:1",state="Disconnected",udn="uuid:75802409-bccb-40e7-8e6c-3431C4A1B2C3",variable="ConnectionStatus"} 0
+fb_state{service="WANPPPConnection:1",state="Unconfigured",udn="uuid:75802409-bccb-40e7-8e6c-3431C4A1B2C3",variable="ConnectionStatus"} 0
+`))
+
+	assert.NoError(t, err)
+}
+
+func Test_ExecuteReturnsDataTypes(t *testing.T) {
+	box := newTestBox(func(action string, args map[string]string) (map[string]string, int) {
+		return map[string]string{"NewModelName": "FRITZ!Box 7590", "NewUpTime": "3600"}, 0
+	})
+	defer box.Close()
+
+	values, err := box.client().Execute(context.Background(), map[string][]string{"DeviceInfo": {"GetInfo"}})
+
+	assert.NoError(t, err)
+	uptime, ok := filterValueByService(values, "DeviceInfo", "GetInfo", "UpTime")
+	assert.True(t, ok)
+	assert.Equal(t, "ui4", uptime.dataType)
+	assert.Equal(t, float64(3600), filterConvertByService(values, "DeviceInfo", "GetInfo", "UpTime"))
+}
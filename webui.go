@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const emptySID = "0000000000000000"
+
+var errSessionInvalid = errors.New("web UI session is not valid")
+
+// WebUIClient queries the data pages of the FritzBox web UI, which provide values not available via TR-064
+type WebUIClient struct {
+	URL      string
+	user     string
+	password string
+	client   *http.Client
+	// guard is shared with the UPnP client, failed logins suspend all requests to the box
+	guard *authGuard
+
+	mu  sync.Mutex
+	sid string
+}
+
+// NewWebUIClient creates a web UI client using the HTTP client (and therefore the TLS settings) and the
+// authentication guard of the UPnP client
+func NewWebUIClient(cfg *Config, client *http.Client, guard *authGuard) *WebUIClient {
+	scheme := "http"
+	if cfg.TLS {
+		scheme = "https"
+	}
+	return &WebUIClient{
+		URL:      fmt.Sprintf("%s://%s", scheme, cfg.URL),
+		user:     cfg.User,
+		password: cfg.Password,
+		client:   client,
+		guard:    guard,
+	}
+}
+
+type sessionInfo struct {
+	SID       string `xml:"SID"`
+	Challenge string `xml:"Challenge"`
+	BlockTime int    `xml:"BlockTime"`
+}
+
+type webUIResponse struct {
+	SID  string          `json:"sid"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Data returns the "data" part of passed web UI page (data.lua), logs in if no valid session exists
+func (wc *WebUIClient) Data(ctx context.Context, page string) (json.RawMessage, error) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	if wc.sid == "" {
+		if err := wc.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := wc.data(ctx, page)
+	if err == errSessionInvalid {
+		// session has expired, try once again with a new one
+		log.Debugf("web UI session expired on page %s, renewing session", page)
+		if err := wc.login(ctx); err != nil {
+			return nil, err
+		}
+		data, err = wc.data(ctx, page)
+	}
+	return data, err
+}
+
+func (wc *WebUIClient) data(ctx context.Context, page string) (json.RawMessage, error) {
+	resp, err := wc.postForm(ctx, "/data.lua", url.Values{
+		"xhr":         {"1"},
+		"sid":         {wc.sid},
+		"lang":        {"en"},
+		"page":        {page},
+		"xhrId":       {"all"},
+		"no_sidrenew": {""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, errSessionInvalid
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code was %d", resp.StatusCode)
+	}
+
+	var result webUIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.SID) > 0 && result.SID != wc.sid {
+		return nil, errSessionInvalid
+	}
+	return result.Data, nil
+}
+
+// postForm posts the form to passed path of the web UI
+func (wc *WebUIClient) postForm(ctx context.Context, path string, form url.Values) (*http.Response, error) {
+	req := newRequest(ctx, "POST", wc.URL+path, form.Encode())
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return wc.client.Do(req)
+}
+
+func (wc *WebUIClient) sessionInfo(ctx context.Context, form url.Values) (*sessionInfo, error) {
+	var resp *http.Response
+	var err error
+	if form == nil {
+		resp, err = wc.client.Do(newRequest(ctx, "GET", wc.URL+"/login_sid.lua?version=2", ""))
+	} else {
+		resp, err = wc.postForm(ctx, "/login_sid.lua?version=2", form)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var info sessionInfo
+	if err := xml.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (wc *WebUIClient) login(ctx context.Context) error {
+	wc.sid = ""
+
+	if err := wc.guard.check(wc.URL); err != nil {
+		return err
+	}
+
+	info, err := wc.sessionInfo(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if info.BlockTime > 0 {
+		return fmt.Errorf("web UI login is blocked for %d seconds", info.BlockTime)
+	}
+
+	response, err := challengeResponse(info.Challenge, wc.password)
+	if err != nil {
+		return err
+	}
+
+	info, err = wc.sessionInfo(ctx, url.Values{
+		"username": {wc.user},
+		"response": {response},
+	})
+	if err != nil {
+		return err
+	}
+	if info.SID == emptySID || info.SID == "" {
+		wc.guard.failure()
+		return &AuthError{URL: wc.URL}
+	}
+
+	wc.guard.success()
+	log.Debug("web UI login successful")
+	wc.sid = info.SID
+	return nil
+}
+
+// challengeResponse calculates the login response, PBKDF2 for challenges starting with "2$", MD5 otherwise
+func challengeResponse(challenge string, password string) (string, error) {
+	if !strings.HasPrefix(challenge, "2$") {
+		utf16le := make([]byte, 0)
+		for _, r := range utf16.Encode([]rune(challenge + "-" + password)) {
+			utf16le = append(utf16le, byte(r), byte(r>>8))
+		}
+		return fmt.Sprintf("%s-%x", challenge, md5.Sum(utf16le)), nil
+	}
+
+	// 2$<iter1>$<salt1>$<iter2>$<salt2>
+	parts := strings.Split(challenge, "$")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("invalid challenge '%s'", challenge)
+	}
+	iter1, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", err
+	}
+	salt1, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	iter2, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return "", err
+	}
+	salt2, err := hex.DecodeString(parts[4])
+	if err != nil {
+		return "", err
+	}
+
+	hash1 := pbkdf2SHA256([]byte(password), salt1, iter1)
+	hash2 := pbkdf2SHA256(hash1, salt2, iter2)
+
+	return fmt.Sprintf("%s$%x", parts[4], hash2), nil
+}
+
+// pbkdf2SHA256 derives a key with the length of one SHA256 block (RFC 8018)
+func pbkdf2SHA256(password []byte, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+
+	blockIndex := make([]byte, 4)
+	binary.BigEndian.PutUint32(blockIndex, 1)
+	prf.Write(salt)
+	prf.Write(blockIndex)
+	u := prf.Sum(nil)
+
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_challengeResponsePBKDF2(t *testing.T) {
+	response, err := challengeResponse("2$10000$5A1711$2000$5A1722", "1example!")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "5A1722$1798a1672bca7c6463d6b245f82b53703b0f50813401b03e4045a5861e689adb", response)
+}
+
+func Test_challengeResponseMD5(t *testing.T) {
+	response, err := challengeResponse("1234567z", "äbc")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "1234567z-9e224a41eeefa284df7bb0f26c2913e2", response)
+}
+
+func Test_challengeResponseInvalid(t *testing.T) {
+	_, err := challengeResponse("2$10000$5A1711", "secret")
+
+	assert.Error(t, err)
+}
+
+// newTestWebUI returns a web UI which issues a new SID on each login, data.lua responds with passed handler
+func newTestWebUI(data func(sid string, page string) (int, string)) (*httptest.Server, *int) {
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.URL.Path {
+		case "/login_sid.lua":
+			sid := emptySID
+			if len(req.PostForm.Get("response")) > 0 {
+				logins++
+				sid = fmt.Sprintf("%016d", logins)
+			}
+			fmt.Fprintf(rw, "<SessionInfo><SID>%s</SID><Challenge>1234567z</Challenge><BlockTime>0</BlockTime></SessionInfo>", sid)
+		case "/data.lua":
+			status, body := data(req.PostForm.Get("sid"), req.PostForm.Get("page"))
+			rw.WriteHeader(status)
+			rw.Write([]byte(body))
+		}
+	}))
+	return server, &logins
+}
+
+func Test_DataRenewsSessionOnForbidden(t *testing.T) {
+	server, logins := newTestWebUI(func(sid string, page string) (int, string) {
+		if sid == fmt.Sprintf("%016d", 1) {
+			return http.StatusForbidden, ""
+		}
+		return http.StatusOK, fmt.Sprintf(`{"sid": "%s", "data": {"page": "%s"}}`, sid, page)
+	})
+	defer server.Close()
+	sut := NewWebUIClient(&Config{User: "user", Password: "secret"}, server.Client(), newAuthGuard())
+	sut.URL = server.URL
+
+	data, err := sut.Data(context.Background(), docsisPage)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"page": "docInfo"}`, string(data))
+	assert.Equal(t, 2, *logins)
+
+	// session is kept afterwards
+	_, err = sut.Data(context.Background(), fiberPage)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *logins)
+}
+
+func Test_DataRenewsSessionOnChangedSID(t *testing.T) {
+	server, logins := newTestWebUI(func(sid string, page string) (int, string) {
+		// the box answers with an empty session if the SID has expired
+		if sid == fmt.Sprintf("%016d", 1) {
+			return http.StatusOK, fmt.Sprintf(`{"sid": "%s", "data": {}}`, emptySID)
+		}
+		return http.StatusOK, fmt.Sprintf(`{"sid": "%s", "data": {"page": "%s"}}`, sid, page)
+	})
+	defer server.Close()
+	sut := NewWebUIClient(&Config{User: "user", Password: "secret"}, server.Client(), newAuthGuard())
+	sut.URL = server.URL
+
+	data, err := sut.Data(context.Background(), docsisPage)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"page": "docInfo"}`, string(data))
+	assert.Equal(t, 2, *logins)
+}
+
+func Test_DataFailsIfRenewedSessionIsInvalid(t *testing.T) {
+	server, logins := newTestWebUI(func(sid string, page string) (int, string) {
+		return http.StatusForbidden, ""
+	})
+	defer server.Close()
+	sut := NewWebUIClient(&Config{User: "user", Password: "secret"}, server.Client(), newAuthGuard())
+	sut.URL = server.URL
+
+	_, err := sut.Data(context.Background(), docsisPage)
+
+	assert.Equal(t, errSessionInvalid, err)
+	assert.Equal(t, 2, *logins)
+}
+
+func Test_parseDocsisInfo(t *testing.T) {
+	info, err := parseDocsisInfo([]byte(`{
+		"channelDs": {
+			"docsis30": [{"channelID": 7, "frequency": "602", "modulation": "256QAM", "powerLevel": "3.5", "mse": "-37.6", "mer": "---", "corrErrors": 12, "nonCorrErrors": "0"}],
+			"docsis31": [{"channelID": "33", "frequency": "751 - 846", "type": "4096QAM", "powerLevel": 8.1, "mer": "43"}]
+		},
+		"channelUs": {
+			"docsis30": [{"channelID": 2, "frequency": "30.8", "modulation": "64QAM", "powerLevel": "---"}]
+		}
+	}`))
+
+	assert.NoError(t, err)
+	ds30 := info.Downstream.Docsis30[0]
+	assert.Equal(t, flexFloat{value: 7, set: true}, ds30.ChannelID)
+	assert.Equal(t, flexFloat{value: 3.5, set: true}, ds30.PowerLevel)
+	assert.Equal(t, flexFloat{value: -37.6, set: true}, ds30.MSE)
+	assert.False(t, ds30.MER.set, "placeholder")
+	assert.Equal(t, flexFloat{value: 12, set: true}, ds30.CorrErrors)
+	assert.Equal(t, flexFloat{value: 0, set: true}, ds30.NonCorrErrors)
+
+	ds31 := info.Downstream.Docsis31[0]
+	assert.Equal(t, "4096QAM", ds31.modulation())
+	assert.Equal(t, flexFloat{value: 43, set: true}, ds31.MER)
+	assert.False(t, ds31.MSE.set, "missing")
+
+	assert.False(t, info.Upstream.Docsis30[0].PowerLevel.set, "placeholder")
+	assert.Empty(t, info.Upstream.Docsis31)
+}
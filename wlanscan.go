@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// web UI data page with the WLAN environment scan
+const wlanScanPage = "chan"
+
+type wlanNeighbour struct {
+	SSID      string    `json:"ssid"`
+	MAC       string    `json:"mac"`
+	Channel   flexFloat `json:"channel"`
+	RSSI      flexFloat `json:"rssi"`
+	Bandwidth flexFloat `json:"bandwidth"`
+	// center frequency of the channel in MHz
+	Frequency flexFloat `json:"frequency"`
+}
+
+type wlanScan struct {
+	Neighbours []wlanNeighbour `json:"scanlist"`
+}
+
+// wlanChannel identifies a channel, channel numbers are only unique within a band
+type wlanChannel struct {
+	band   string
+	number int
+}
+
+type channelStats struct {
+	neighbours int
+	// strongestSignal is only valid if a neighbour reported its signal
+	strongestSignal float64
+	signal          bool
+}
+
+// frequencyBand returns the WLAN band of passed frequency in MHz, empty if it is unknown
+func frequencyBand(frequency flexFloat) string {
+	switch {
+	case !frequency.set || frequency.value < 2400:
+		return ""
+	case frequency.value < 2500:
+		return "2.4GHz"
+	case frequency.value < 5925:
+		return "5GHz"
+	default:
+		return "6GHz"
+	}
+}
+
+// channelStats returns the number of neighbours and the strongest signal per channel
+func (s *wlanScan) channelStats() map[wlanChannel]*channelStats {
+	result := make(map[wlanChannel]*channelStats)
+	for _, n := range s.Neighbours {
+		channel := wlanChannel{band: frequencyBand(n.Frequency), number: int(n.Channel.value)}
+		if channel.number <= 0 {
+			continue
+		}
+		stats, ok := result[channel]
+		if !ok {
+			stats = &channelStats{}
+			result[channel] = stats
+		}
+		stats.neighbours++
+		if n.RSSI.set && (!stats.signal || n.RSSI.value > stats.strongestSignal) {
+			stats.strongestSignal = n.RSSI.value
+			stats.signal = true
+		}
+	}
+	return result
+}
+
+func (collector *FritzBoxCollector) collectWLANEnvironment(ctx context.Context, ch chan<- prometheus.Metric) {
+	data, err := collector.webUI.Data(ctx, wlanScanPage)
+	if err != nil {
+		log.Warnf("can't read WLAN environment: %v", err)
+		return
+	}
+	var scan wlanScan
+	if err := json.Unmarshal(data, &scan); err != nil {
+		log.Debugf("no WLAN environment available: %v", err)
+		return
+	}
+
+	for channel, stats := range scan.channelStats() {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+			"fb_wlan_neighbours",
+			"Number of neighbouring access points on the channel",
+			[]string{"band", "channel"},
+			nil,
+		), prometheus.GaugeValue, float64(stats.neighbours), channel.band, strconv.Itoa(channel.number))
+
+		if stats.signal {
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wlan_neighbour_strongest_signal_dbm",
+				"Signal of the strongest neighbouring access point on the channel in dBm",
+				[]string{"band", "channel"},
+				nil,
+			), prometheus.GaugeValue, stats.strongestSignal, channel.band, strconv.Itoa(channel.number))
+		}
+	}
+}
+
+// collectWLANRadios exports channel, channel width and utilization of the access point radios of the mesh master
+func (collector *FritzBoxCollector) collectWLANRadios(ch chan<- prometheus.Metric, mesh *meshList) {
+	for _, n := range mesh.Nodes {
+		if n.MeshRole != "master" {
+			continue
+		}
+		for _, i := range n.Interfaces {
+			if i.Type != "WLAN" || i.CurrentChannel <= 0 {
+				continue
+			}
+
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wlan_radio_channel",
+				"Current channel of the WLAN radio",
+				[]string{"interface", "band", "ssid"},
+				nil,
+			), prometheus.GaugeValue, float64(i.CurrentChannel), i.Name, i.band(), i.SSID)
+
+			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+				"fb_wlan_radio_channel_utilization_percent",
+				"Utilization of the current channel of the WLAN radio in percent",
+				[]string{"interface", "band", "ssid"},
+				nil,
+			), prometheus.GaugeValue, i.ChannelUtilization, i.Name, i.band(), i.SSID)
+
+			if i.CurrentChannelInfo.ChannelWidth > 0 {
+				ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+					"fb_wlan_radio_channel_width_mhz",
+					"Channel width of the WLAN radio in MHz",
+					[]string{"interface", "band", "ssid"},
+					nil,
+				), prometheus.GaugeValue, i.CurrentChannelInfo.ChannelWidth, i.Name, i.band(), i.SSID)
+			}
+		}
+	}
+}
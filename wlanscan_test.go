@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_wlanScanChannelStats(t *testing.T) {
+	var scan wlanScan
+	err := json.Unmarshal([]byte(`{"scanlist": [
+		{"ssid": "a", "channel": "6", "frequency": "2437", "rssi": "-80"},
+		{"ssid": "b", "channel": 6, "frequency": 2437, "rssi": -55},
+		{"ssid": "c", "channel": 36, "frequency": 5180, "rssi": -70},
+		{"ssid": "d", "channel": 5, "frequency": 5975, "rssi": -75},
+		{"ssid": "e", "channel": 36, "rssi": -60}
+	]}`), &scan)
+	assert.NoError(t, err)
+
+	stats := scan.channelStats()
+
+	assert.Len(t, stats, 4)
+	assert.Equal(t, 2, stats[wlanChannel{"2.4GHz", 6}].neighbours)
+	assert.Equal(t, float64(-55), stats[wlanChannel{"2.4GHz", 6}].strongestSignal)
+	assert.Equal(t, 1, stats[wlanChannel{"5GHz", 36}].neighbours)
+	assert.Equal(t, 1, stats[wlanChannel{"6GHz", 5}].neighbours)
+	// channel numbers overlap between the bands, the band stays unknown without frequency
+	assert.Equal(t, 1, stats[wlanChannel{"", 36}].neighbours)
+}
+
+func Test_meshInterfaceBand(t *testing.T) {
+	for name, expected := range map[string]string{
+		"AP:2G:0": "2.4GHz",
+		"AP:5G:1": "5GHz",
+		"AP:6G:0": "6GHz",
+		"LAN:1":   "",
+	} {
+		i := meshInterface{Name: name}
+		assert.Equal(t, expected, i.band(), name)
+	}
+}